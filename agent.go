@@ -0,0 +1,350 @@
+package fengchaogo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// DefaultAgentMaxSteps Agent默认最多的步数
+const DefaultAgentMaxSteps = 10
+
+var (
+	// ErrAgentMaxSteps Agent的步数超过限制
+	ErrAgentMaxSteps = errors.New("agent max steps exceeded")
+	// ErrAgentBudgetExceeded Agent消耗的token数或者费用超过预算
+	ErrAgentBudgetExceeded = errors.New("agent budget exceeded")
+)
+
+// AgentEventType Agent事件的类型
+type AgentEventType string
+
+const (
+	// AgentEventThought 模型调用工具之前的思考
+	AgentEventThought AgentEventType = "thought"
+	// AgentEventToolCall 模型调用工具
+	AgentEventToolCall AgentEventType = "tool_call"
+	// AgentEventToolResult 工具的执行结果
+	AgentEventToolResult AgentEventType = "tool_result"
+	// AgentEventFinalAnswer 最终的回答
+	AgentEventFinalAnswer AgentEventType = "final_answer"
+)
+
+// AgentUsage Agent的累计消耗
+type AgentUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	// Cost 根据模型列表中的价格计算的费用, 只在设置了费用预算时计算, 模型不在列表中时为0
+	Cost float64
+}
+
+// AgentEvent Agent执行过程中的事件
+type AgentEvent struct {
+	// Type 事件类型
+	Type AgentEventType
+	// Step 所在的步数, 从1开始, 每一步为一次模型请求
+	Step int
+	// Content 思考、工具的执行结果或者最终的回答
+	Content string
+	// ToolCall 调用的工具, 只在工具调用和执行结果事件中出现
+	ToolCall *ToolCall
+	// Err 工具执行的错误, 只在执行结果事件中出现, 错误信息会作为结果返回给模型
+	Err error
+	// Usage 事件发生时的累计消耗
+	Usage AgentUsage
+}
+
+// AgentResult Agent的执行结果
+type AgentResult struct {
+	// Answer 最终的回答
+	Answer string
+	// Events 执行过程中的所有事件
+	Events []AgentEvent
+	// Steps 执行的步数
+	Steps int
+	// Usage 累计消耗
+	Usage AgentUsage
+}
+
+// Memory Agent的记忆, 保存之前的对话以及工具调用的过程, 每次执行时加载到系统消息之后
+type Memory interface {
+	// Load 加载记忆中的消息
+	Load(ctx context.Context) ([]*Message, error)
+	// Save 保存一次执行的消息, 包括输入、工具调用、执行结果和最终的回答
+	Save(ctx context.Context, messages ...*Message) error
+}
+
+// BufferMemory 在内存中保存最近的消息, 并发安全
+type BufferMemory struct {
+	limit    int
+	messages []*Message
+	mu       sync.Mutex
+}
+
+var _ Memory = (*BufferMemory)(nil)
+
+// NewBufferMemory 创建内存记忆, 最多保存limit条消息, 小于等于0时不限制
+// 超过限制时从最早的消息开始删除, 并保证第一条消息为用户消息, 避免留下不完整的工具调用
+func NewBufferMemory(limit int) *BufferMemory {
+	return &BufferMemory{limit: limit}
+}
+
+// Load 加载记忆中的消息
+func (m *BufferMemory) Load(context.Context) ([]*Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.messages), nil
+}
+
+// Save 保存消息
+func (m *BufferMemory) Save(_ context.Context, messages ...*Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, messages...)
+	if m.limit > 0 && len(m.messages) > m.limit {
+		m.messages = m.messages[len(m.messages)-m.limit:]
+		for len(m.messages) > 0 && m.messages[0].Role != RoleUser {
+			m.messages = m.messages[1:]
+		}
+	}
+	return nil
+}
+
+// Agent 使用工具完成任务的Agent, 循环进行思考、调用工具、观察结果, 直到给出最终的回答
+type Agent struct {
+	client *FengChao
+	system Prompt
+	tools  *ToolRegistry
+
+	// maxSteps 最多的步数
+	maxSteps int
+	// tokenBudget 最多消耗的token数, 为0时不限制
+	tokenBudget int
+	// costBudget 最多消耗的费用, 为0时不限制
+	costBudget float64
+	// memory 记忆, 为空时每次执行都是独立的
+	memory Memory
+	// chatOptions 每次请求的配置
+	chatOptions []Option[ChatCompletion]
+}
+
+// WithAgentMaxSteps 设置最多的步数, 每一步为一次模型请求
+func WithAgentMaxSteps(steps int) Option[Agent] {
+	return func(option *Agent) {
+		option.maxSteps = steps
+	}
+}
+
+// WithAgentTokenBudget 设置最多消耗的token数, 超过时停止执行
+func WithAgentTokenBudget(tokens int) Option[Agent] {
+	return func(option *Agent) {
+		option.tokenBudget = tokens
+	}
+}
+
+// WithAgentCostBudget 设置最多消耗的费用, 根据模型列表中的价格计算, 超过时停止执行
+func WithAgentCostBudget(cost float64) Option[Agent] {
+	return func(option *Agent) {
+		option.costBudget = cost
+	}
+}
+
+// WithAgentMemory 设置记忆, 多次执行之间共享对话和工具调用的过程
+func WithAgentMemory(memory Memory) Option[Agent] {
+	return func(option *Agent) {
+		option.memory = memory
+	}
+}
+
+// WithAgentChatOptions 设置每次请求的配置, 例如模型和系统消息模板的变量
+func WithAgentChatOptions(options ...Option[ChatCompletion]) Option[Agent] {
+	return func(option *Agent) {
+		option.chatOptions = append(option.chatOptions, options...)
+	}
+}
+
+// NewAgent 创建Agent, system为系统消息模板, tools为可以使用的工具, 可以为空
+func NewAgent(client *FengChao, system Prompt, tools *ToolRegistry, options ...Option[Agent]) *Agent {
+	if tools == nil {
+		tools = NewToolRegistry()
+	}
+	agent := &Agent{
+		client:   client,
+		system:   system,
+		tools:    tools,
+		maxSteps: DefaultAgentMaxSteps,
+	}
+	for _, option := range options {
+		option(agent)
+	}
+	return agent
+}
+
+// Run 执行任务直到给出最终的回答, 失败时返回已经执行的部分结果
+func (a *Agent) Run(ctx context.Context, input string) (*AgentResult, error) {
+	result := &AgentResult{}
+	for event, err := range a.RunStream(ctx, input) {
+		if err != nil {
+			return result, err
+		}
+		result.Events = append(result.Events, event)
+		result.Steps = event.Step
+		result.Usage = event.Usage
+		if event.Type == AgentEventFinalAnswer {
+			result.Answer = event.Content
+		}
+	}
+	return result, nil
+}
+
+// RunStream 执行任务, 按照顺序返回执行过程中的事件, 出错时返回错误并结束
+// 停止迭代时会取消正在执行的请求和工具
+func (a *Agent) RunStream(ctx context.Context, input string) iter.Seq2[AgentEvent, error] {
+	return func(yield func(AgentEvent, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		run := &agentRun{agent: a, yield: yield}
+		if err := run.run(ctx, input); err != nil && !run.stopped {
+			yield(AgentEvent{Step: run.step, Usage: run.usage}, err)
+		}
+	}
+}
+
+// agentRun 一次执行的状态
+type agentRun struct {
+	agent   *Agent
+	yield   func(AgentEvent, error) bool
+	step    int
+	usage   AgentUsage
+	stopped bool
+}
+
+// emit 发送事件, 调用方停止迭代时返回false
+func (r *agentRun) emit(event AgentEvent) bool {
+	event.Step = r.step
+	event.Usage = r.usage
+	if !r.yield(event, nil) {
+		r.stopped = true
+	}
+	return !r.stopped
+}
+
+// run 执行任务, 调用方停止迭代时返回nil
+func (r *agentRun) run(ctx context.Context, input string) error {
+	a, f := r.agent, r.agent.client
+	var history []*Message
+	if a.memory != nil {
+		var err error
+		if history, err = a.memory.Load(ctx); err != nil {
+			return fmt.Errorf("load agent memory: %w", err)
+		}
+	}
+
+	params := f.newChatCompletion(a.chatOptions...)
+	prompts := []Prompt{}
+	if a.system != nil {
+		system, err := a.system.RenderMessages(params.variables)
+		if err != nil {
+			return fmt.Errorf("fail to render system prompt cause: %w", err)
+		}
+		for _, m := range system {
+			prompts = append(prompts, m)
+		}
+	}
+	for _, m := range history {
+		prompts = append(prompts, m)
+	}
+	// 输入不作为模板渲染
+	prompts = append(prompts, &Message{Role: RoleUser, Content: input})
+	if _, err := params.LoadPromptTemplates(NewPromptTemplate(prompts...)); err != nil {
+		return fmt.Errorf("fail to load prompt template cause: %w", err)
+	}
+	params.Tools = append(params.Tools, a.tools.Definitions()...)
+
+	// scratchpad 本次执行的消息, 结束后保存到记忆中
+	start := len(params.History)
+	messages := append(slices.Clone(params.History), &Message{Role: RoleUser, Content: params.Query, Parts: params.QueryParts})
+	for {
+		if r.step >= a.maxSteps {
+			return fmt.Errorf("%w: no final answer after %d steps", ErrAgentMaxSteps, a.maxSteps)
+		}
+		r.step++
+		result, err := f.chat(ctx, RequestInvoke, params)
+		if result != nil {
+			r.addUsage(ctx, result.Model, result)
+		}
+		if err != nil {
+			return err
+		}
+
+		var reply Message
+		if len(result.Choices) > 0 {
+			reply = result.Choices[0].Message
+		}
+		messages = append(messages, &Message{Role: RoleAssistant, Content: reply.Content, ToolCalls: reply.ToolCalls})
+		if len(reply.ToolCalls) == 0 {
+			if a.memory != nil {
+				if err := a.memory.Save(ctx, messages[start:]...); err != nil {
+					return fmt.Errorf("save agent memory: %w", err)
+				}
+			}
+			r.emit(AgentEvent{Type: AgentEventFinalAnswer, Content: reply.Content})
+			return nil
+		}
+
+		if strings.TrimSpace(reply.Content) != "" && !r.emit(AgentEvent{Type: AgentEventThought, Content: reply.Content}) {
+			return nil
+		}
+		for i := range reply.ToolCalls {
+			if !r.emit(AgentEvent{Type: AgentEventToolCall, ToolCall: &reply.ToolCalls[i]}) {
+				return nil
+			}
+		}
+		results, errs := f.executeTools(ctx, a.tools, reply.ToolCalls)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for i, m := range results {
+			if !r.emit(AgentEvent{Type: AgentEventToolResult, ToolCall: &reply.ToolCalls[i], Content: m.Content, Err: errs[i]}) {
+				return nil
+			}
+		}
+		messages = append(messages, results...)
+
+		if a.tokenBudget > 0 && r.usage.TotalTokens >= a.tokenBudget {
+			return fmt.Errorf("%w: used %d tokens, budget %d", ErrAgentBudgetExceeded, r.usage.TotalTokens, a.tokenBudget)
+		}
+		if a.costBudget > 0 && r.usage.Cost >= a.costBudget {
+			return fmt.Errorf("%w: cost %g, budget %g", ErrAgentBudgetExceeded, r.usage.Cost, a.costBudget)
+		}
+
+		// 工具的执行结果通过历史消息发送
+		params = params.Clone()
+		params.History = slices.Clone(messages)
+		params.Query = ""
+		params.QueryParts = nil
+		// 必须调用工具只对第一步生效, 之后由模型决定是否继续调用工具并给出回答
+		if params.ToolChoice == ToolChoiceRequired {
+			params.ToolChoice = ToolChoiceAuto
+		}
+	}
+}
+
+// addUsage 累计消耗的token数和费用, model为实际回答的模型, 切换到备用模型时使用备用模型的价格
+func (r *agentRun) addUsage(ctx context.Context, model string, result *ChatCompletionResult) {
+	r.usage.PromptTokens += result.Usage.PromptTokens
+	r.usage.CompletionTokens += result.Usage.CompletionTokens
+	r.usage.TotalTokens += result.Usage.TotalTokens
+	// 只在需要时查询模型的价格
+	if r.agent.costBudget <= 0 {
+		return
+	}
+	if m := r.agent.client.getModel(ctx, model); m != nil {
+		r.usage.Cost += m.Cost(result.Usage.PromptTokens, result.Usage.CompletionTokens)
+	}
+}
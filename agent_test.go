@@ -0,0 +1,218 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+// anyRequest 匹配所有的对话请求
+func anyRequest(*fengchao.ChatCompletion) bool { return true }
+
+// weatherCall 调用天气工具
+func weatherCall(id, city string) fengchao.ToolCall {
+	return fengchao.ToolCall{ID: id, Type: fengchao.ToolTypeFunction, Function: fengchao.FunctionCall{Name: "get_weather", Arguments: `{"city": "` + city + `"}`}}
+}
+
+func newWeatherAgent(server *fengchaotest.Server, options ...fengchao.Option[fengchao.Agent]) *fengchao.Agent {
+	return fengchao.NewAgent(server.Client(),
+		fengchao.NewMessage(fengchao.RoleSystem, "你是{{.Name}}, 可以查询天气"),
+		fengchao.NewToolRegistry(weatherTool()),
+		append([]fengchao.Option[fengchao.Agent]{fengchao.WithAgentChatOptions(fengchao.WithParams(map[string]any{"Name": "天气助手"}))}, options...)...,
+	)
+}
+
+func TestAgentRun(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.On(anyRequest,
+		fengchaotest.Reply{Content: "需要先查询北京的天气", ToolCalls: []fengchao.ToolCall{weatherCall("call_1", "北京")}, PromptTokens: 10, CompletionTokens: 5},
+		fengchaotest.Reply{Content: "北京晴, 25度", PromptTokens: 20, CompletionTokens: 5},
+	)
+	memory := fengchao.NewBufferMemory(0)
+	agent := newWeatherAgent(server, fengchao.WithAgentMemory(memory))
+
+	result, err := agent.Run(context.Background(), "北京天气怎么样? {{不是模板}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Answer != "北京晴, 25度" || result.Steps != 2 || result.Usage.TotalTokens != 40 {
+		t.Fatalf("got %+v", result)
+	}
+	types := []fengchao.AgentEventType{}
+	for _, event := range result.Events {
+		types = append(types, event.Type)
+	}
+	want := []fengchao.AgentEventType{fengchao.AgentEventThought, fengchao.AgentEventToolCall, fengchao.AgentEventToolResult, fengchao.AgentEventFinalAnswer}
+	if len(types) != len(want) {
+		t.Fatalf("got events %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("got events %v, want %v", types, want)
+		}
+	}
+	if e := result.Events[2]; e.Content != `{"city":"北京","temperature":25}` || e.ToolCall.ID != "call_1" || e.Err != nil || e.Step != 1 {
+		t.Fatalf("got tool result %+v", e)
+	}
+
+	requests := server.Requests()
+	if requests[0].System != "你是天气助手, 可以查询天气" || requests[0].Query != "北京天气怎么样? {{不是模板}}" || len(requests[0].Tools) != 1 {
+		t.Fatalf("got first request %+v", requests[0])
+	}
+
+	// 第二次执行时加载记忆中的对话和工具调用过程
+	saved, _ := memory.Load(context.Background())
+	if len(saved) != 4 {
+		t.Fatalf("got %d messages in memory, want 4", len(saved))
+	}
+	if _, err := agent.Run(context.Background(), "上海呢"); err != nil {
+		t.Fatal(err)
+	}
+	last := server.LastRequest()
+	if len(last.History) != 4 || last.History[0].Content != "北京天气怎么样? {{不是模板}}" || last.Query != "上海呢" {
+		t.Fatalf("memory not loaded: %+v", last)
+	}
+}
+
+func TestAgentToolChoiceRequiredOnce(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.On(anyRequest,
+		fengchaotest.Reply{ToolCalls: []fengchao.ToolCall{weatherCall("call_1", "北京")}},
+		fengchaotest.Reply{Content: "北京晴, 25度"},
+	)
+	agent := newWeatherAgent(server, fengchao.WithAgentChatOptions(fengchao.WithToolChoice(fengchao.ToolChoiceRequired)))
+
+	result, err := agent.Run(context.Background(), "北京天气怎么样")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Answer != "北京晴, 25度" || result.Steps != 2 {
+		t.Fatalf("got %+v", result)
+	}
+	// 必须调用工具只对第一步生效
+	requests := server.Requests()
+	if requests[0].ToolChoice != fengchao.ToolChoiceRequired || requests[1].ToolChoice != fengchao.ToolChoiceAuto {
+		t.Fatalf("got tool choices %q and %q", requests[0].ToolChoice, requests[1].ToolChoice)
+	}
+}
+
+func TestAgentRunStreamStop(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{ToolCalls: []fengchao.ToolCall{weatherCall("call_1", "北京")}})
+	agent := newWeatherAgent(server)
+
+	for event, err := range agent.RunStream(context.Background(), "北京天气怎么样") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Type == fengchao.AgentEventToolCall {
+			break
+		}
+	}
+	if len(server.Requests()) != 1 {
+		t.Fatalf("got %d requests after stopping, want 1", len(server.Requests()))
+	}
+}
+
+func TestAgentLimits(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{ToolCalls: []fengchao.ToolCall{weatherCall("call_1", "北京")}, PromptTokens: 1000, CompletionTokens: 1000})
+	server.Models = []fengchao.Model{{ID: "ERNIE-Bot-4", InPrice: 0.12, OutPrice: 0.12, Unit: "1k tokens"}}
+
+	tests := []struct {
+		name  string
+		agent *fengchao.Agent
+		want  error
+		steps int
+	}{
+		{"max steps", newWeatherAgent(server, fengchao.WithAgentMaxSteps(3)), fengchao.ErrAgentMaxSteps, 3},
+		{"token budget", newWeatherAgent(server, fengchao.WithAgentTokenBudget(5000)), fengchao.ErrAgentBudgetExceeded, 3},
+		{"cost budget", newWeatherAgent(server, fengchao.WithAgentCostBudget(0.4)), fengchao.ErrAgentBudgetExceeded, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.agent.Run(context.Background(), "北京天气怎么样")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if result.Steps != tt.steps {
+				t.Fatalf("got %d steps, want %d", result.Steps, tt.steps)
+			}
+		})
+	}
+
+	result, _ := newWeatherAgent(server, fengchao.WithAgentMaxSteps(1), fengchao.WithAgentCostBudget(100)).Run(context.Background(), "北京天气怎么样")
+	if math.Abs(result.Usage.Cost-0.24) > 1e-9 {
+		t.Fatalf("got cost %v, want 0.24", result.Usage.Cost)
+	}
+}
+
+func TestAgentFallbackPricing(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.Models = []fengchao.Model{
+		{ID: "ERNIE-Bot-4", InPrice: 0.12, OutPrice: 0.12, Unit: "1k tokens"},
+		{ID: "glm-4", InPrice: 1.2, OutPrice: 1.2, Unit: "1k tokens"},
+	}
+	server.OnModel("ERNIE-Bot-4", fengchaotest.Reply{HTTPStatus: http.StatusServiceUnavailable, Msg: "model is not available"})
+	server.SetDefault(fengchaotest.Reply{Content: "北京晴", PromptTokens: 1000, CompletionTokens: 1000})
+
+	// 备用模型回答时使用备用模型的价格
+	agent := newWeatherAgent(server, fengchao.WithAgentCostBudget(100), fengchao.WithAgentChatOptions(fengchao.WithFallbackModels("glm-4")))
+	result, err := agent.Run(context.Background(), "北京天气怎么样")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(result.Usage.Cost-2.4) > 1e-9 {
+		t.Fatalf("got cost %v, want 2.4", result.Usage.Cost)
+	}
+}
+
+func TestAgentPricingOnlyWithCostBudget(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.On(anyRequest,
+		fengchaotest.Reply{ToolCalls: []fengchao.ToolCall{weatherCall("call_1", "北京")}},
+		fengchaotest.Reply{Content: "北京晴"},
+	)
+	var loads atomic.Int32
+	client := server.Client(fengchao.WithMiddleware(failingModels(&loads)))
+
+	agent := fengchao.NewAgent(client, nil, fengchao.NewToolRegistry(weatherTool()))
+	if _, err := agent.Run(context.Background(), "北京天气怎么样"); err != nil {
+		t.Fatal(err)
+	}
+	if n := loads.Load(); n != 0 {
+		t.Fatalf("got %d model loads without cost budget, want 0", n)
+	}
+}
+
+func TestAgentCancel(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{ToolCalls: []fengchao.ToolCall{{ID: "call_1", Function: fengchao.FunctionCall{Name: "wait", Arguments: "{}"}}}})
+	agent := fengchao.NewAgent(server.Client(), nil, fengchao.NewToolRegistry(
+		fengchao.NewFunctionTool("wait", "等待", func(ctx context.Context, args struct{}) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}),
+	))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := agent.Run(ctx, "开始")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -2,16 +2,117 @@ package fengchaogo
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
 const ExpiresTime = 1700
 
-// authManager 授权控制器
+// DefaultTokenRefreshWindow 默认在token过期前多久开始后台刷新
+const DefaultTokenRefreshWindow = 60 * time.Second
+
+// TokenRefreshRetryInterval 后台刷新失败后继续使用仍然有效的token, 间隔多久再次在后台刷新
+const TokenRefreshRetryInterval = 10 * time.Second
+
+// authManager 授权控制器, 并发安全
+// 同一时间只有一个刷新请求, 其他请求等待刷新结果; 刷新失败时保留仍然有效的token
 type authManager struct {
 	accessToken string
 	refreshAt   time.Time
+	expiresAt   time.Time
+	// retryAt 刷新失败后, 这个时间之前不在后台刷新
+	retryAt time.Time
+	// refreshing 刷新中时不为空, 刷新结束后关闭
+	refreshing chan struct{}
+	// lastErr 最后一次刷新的错误
+	lastErr error
+	mu      sync.Mutex
+}
+
+// tokenRefresher 刷新token, 返回token和过期时间
+type tokenRefresher func(ctx context.Context) (string, time.Time, error)
+
+// token 获取token, 没有有效的token时等待刷新, 即将过期时在后台刷新并继续使用当前的token
+// 后台刷新失败后间隔TokenRefreshRetryInterval再次刷新, 没有有效的token时不受间隔限制
+// ctx取消时停止等待并返回ctx的错误, 刷新由所有等待的请求共享, 不会因为一个请求取消而中断
+func (a *authManager) token(ctx context.Context, window time.Duration, refresh tokenRefresher) (string, error) {
+	a.mu.Lock()
+	if a.valid() {
+		if time.Until(a.expiresAt) < window && !time.Now().Before(a.retryAt) {
+			a.refresh(ctx, refresh)
+		}
+		token := a.accessToken
+		a.mu.Unlock()
+		return token, nil
+	}
+	done := a.refresh(ctx, refresh)
+	a.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.valid() {
+		return a.accessToken, nil
+	}
+	if a.lastErr != nil {
+		return "", a.lastErr
+	}
+	// 刷新成功但是token为空、已经过期或者刚刚被作废
+	return "", fmt.Errorf("%w: refreshed token is empty or expired", ErrAuth)
+}
+
+// valid token是否有效, 需要持有锁
+func (a *authManager) valid() bool {
+	return a.accessToken != "" && time.Now().Before(a.expiresAt)
+}
+
+// invalidate 作废被服务端拒绝的token, 已经刷新为新的token时不处理
+func (a *authManager) invalidate(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.accessToken == token {
+		a.accessToken = ""
+	}
+}
+
+// refresh 开始刷新, 已经在刷新时返回正在进行的刷新, 需要持有锁
+// 刷新使用ctx中的链路信息, 但是不继承ctx的取消和超时
+func (a *authManager) refresh(ctx context.Context, refresh tokenRefresher) <-chan struct{} {
+	if a.refreshing != nil {
+		return a.refreshing
+	}
+	done := make(chan struct{})
+	a.refreshing = done
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer close(done)
+		token, expiresAt, err := refresh(ctx)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.refreshing = nil
+		a.lastErr = err
+		if err != nil {
+			a.retryAt = time.Now().Add(TokenRefreshRetryInterval)
+			return
+		}
+		a.retryAt = time.Time{}
+		a.accessToken = token
+		a.refreshAt = time.Now()
+		a.expiresAt = expiresAt
+	}()
+	return done
 }
 
 // tokenResponse token响应
@@ -19,51 +120,166 @@ type tokenResponse struct {
 	Status int    `json:"status"`
 	Token  string `json:"token"`
 	Msg    string `json:"msg"`
+	// ExpiresIn token的有效期, 单位为秒
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+	// ExpiresAt token的过期时间, unix时间戳
+	ExpiresAt int64 `json:"expires_at,omitempty"`
 }
 
-// getAuthToken 获取token
-func (f *FengChao) getAuthToken() (string, error) {
+// expiresAt 获取响应中的过期时间, 依次使用expires_in和expires_at, 都没有时为零值
+func (r *tokenResponse) expiresAt(now time.Time) time.Time {
+	switch {
+	case r.ExpiresIn > 0:
+		return now.Add(time.Duration(r.ExpiresIn) * time.Second)
+	case r.ExpiresAt > 0:
+		return time.Unix(r.ExpiresAt, 0)
+	}
+	return time.Time{}
+}
 
-	if f.auth == nil || time.Since(f.auth.refreshAt) > time.Duration(ExpiresTime)*time.Second {
-		err := f.refreshToken()
-		if err != nil {
-			return "", err
-		}
+// parseTokenResponse 解析/token接口的响应
+func parseTokenResponse(resp *Response) (*Token, error) {
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("get auth token response error: %w", handleErrorResponse(resp, ""))
+	}
+	result := &tokenResponse{}
+	if err := json.Unmarshal(resp.Raw, result); err != nil {
+		return nil, fmt.Errorf("get auth token response error: %w", err)
+	}
+	if result.Status != 200 {
+		return nil, fmt.Errorf("get auth token error: %w", newAPIError(resp.StatusCode, result.Status, result.Msg, "", resp.Raw))
+	}
+	return &Token{Value: result.Token, ExpiresAt: result.expiresAt(time.Now())}, nil
+}
+
+// tokenExpiresAt 获取token的过期时间, 依次使用来源返回的过期时间和JWT中的exp, 都没有时使用ExpiresTime
+func tokenExpiresAt(token *Token, now time.Time) time.Time {
+	if !token.ExpiresAt.IsZero() {
+		return token.ExpiresAt
+	}
+	if exp, ok := jwtExpiry(token.Value); ok {
+		return exp
+	}
+	return now.Add(time.Duration(ExpiresTime) * time.Second)
+}
+
+// jwtExpiry 解析JWT中的exp, 不校验签名
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
 	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// authFor 获取凭证在服务地址的授权控制器, 不同服务地址签发的token不能通用
+func (f *FengChao) authFor(ep *endpoint, cred *credential) *authManager {
+	f.endpoints.mu.Lock()
+	defer f.endpoints.mu.Unlock()
+	auth, ok := ep.auth[cred]
+	if !ok {
+		auth = &authManager{}
+		ep.auth[cred] = auth
+	}
+	return auth
+}
+
+// getAuthToken 获取凭证在服务地址的token
+func (f *FengChao) getAuthToken(ctx context.Context, ep *endpoint, cred *credential) (string, error) {
+	return f.authFor(ep, cred).token(ctx, f.tokenRefreshWindow, func(ctx context.Context) (string, time.Time, error) {
+		return f.refreshToken(ctx, ep, cred)
+	})
+}
 
-	return f.auth.accessToken, nil
+// Authenticate 为每个凭证在当前优先使用的服务地址上获取token, 已有有效的token时直接使用
+// 可以在启动时调用以提前发现鉴权配置的错误, 返回所有失败凭证的错误
+func (f *FengChao) Authenticate(ctx context.Context) error {
+	candidates := f.endpoints.candidates()
+	if len(candidates) == 0 {
+		return fmt.Errorf("%w, no endpoint configured", ErrAuth)
+	}
+	var errs []error
+	for _, cred := range f.credentials.credentials {
+		if _, err := f.getAuthToken(ctx, candidates[0], cred); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			errs = append(errs, fmt.Errorf("credential %s: %w", cred.name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w, %w", ErrAuth, errors.Join(errs...))
+	}
+	return nil
 }
 
-// refreshToken 刷新token
-func (f *FengChao) refreshToken() error {
+// refreshToken 从凭证的来源获取服务地址的token
+func (f *FengChao) refreshToken(ctx context.Context, ep *endpoint, cred *credential) (_ string, _ time.Time, err error) {
 	// 设置超时
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(BasicRequestTimeout)*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, f.controlPlaneTimeout)
 	defer cancel()
-	resp, err := f.client.R().
-		SetContext(ctx).
-		SetDebug(false).
-		SetLogger(nil).
-		SetQueryParam("api_key", f.ApiKey).
-		SetQueryParam("secret_key", f.SecretKey).
-		SetResult(&tokenResponse{}).
-		Get("/token")
+	ctx, span := f.startSpan(ctx, RequestToken, nil)
+	metrics := f.startMetrics(RequestToken, "")
+	defer func() {
+		endSpan(span, nil, err)
+		metrics.finish(nil, err)
+		if err != nil {
+			f.metrics.TokenRefreshFailed()
+		}
+	}()
+	start := time.Now()
 
+	var token *Token
+	switch source := cred.source().(type) {
+	case *KeySecretTokenSource:
+		token, err = f.exchangeToken(ctx, ep, source)
+	default:
+		token, err = source.Token(ctx, ep.URL)
+		if err == nil && (token == nil || token.Value == "") {
+			err = errors.New("token source returned an empty token")
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("get auth token client error: %v", err)
+		err = redactError(err)
+		f.logger.Error("fengchao token refresh failed", "endpoint", ep.URL, "credential", cred.name(), "latency", time.Since(start), "error", err)
+		return "", time.Time{}, err
 	}
 
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("get auth token response error: %v", resp)
-	}
+	expiresAt := tokenExpiresAt(token, time.Now())
+	f.logger.Info("fengchao token refreshed", "endpoint", ep.URL, "credential", cred.name(), "token", redact(token.Value), "expires_at", expiresAt, "latency", time.Since(start))
 
-	if resp.Result().(*tokenResponse).Status != 200 {
-		return fmt.Errorf("get auth token error: %v", resp.Result().(*tokenResponse).Msg)
-	}
+	return token.Value, expiresAt, nil
+}
 
-	f.auth = &authManager{
-		accessToken: resp.Result().(*tokenResponse).Token,
-		refreshAt:   time.Now(),
+// exchangeToken 通过中间件链使用api_key和secret_key换取token
+func (f *FengChao) exchangeToken(ctx context.Context, ep *endpoint, source *KeySecretTokenSource) (*Token, error) {
+	resp, err := f.do(ctx, &Request{
+		Kind:     RequestToken,
+		Method:   http.MethodGet,
+		Endpoint: ep.URL,
+		Path:     "/token",
+		Query: url.Values{
+			"api_key":    {source.ApiKey},
+			"secret_key": {source.SecretKey},
+		},
+	})
+	if err != nil {
+		return nil, tokenClientError(err)
 	}
+	return parseTokenResponse(resp)
+}
 
-	return nil
+// tokenClientError 获取token的请求错误, 去掉地址中的api_key和secret_key
+func tokenClientError(err error) error {
+	return fmt.Errorf("get auth token client error: %w", redactError(err))
 }
@@ -0,0 +1,344 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+func TestTokenSingleFlight(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnToken(fengchaotest.Reply{Latency: 100 * time.Millisecond})
+
+	client := server.Client()
+	builder := fengchao.NewBatchChatCompletionBuilder()
+	for i := 0; i < fengchao.BatchMaxSize; i++ {
+		builder.Add(fengchao.NewMessage(fengchao.RoleUser, "你好"))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"))
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, failed, _ := client.BatchChatCompletion(context.Background(), builder)
+			for _, err := range failed {
+				errs <- err
+				return
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if server.TokenRequests() != 1 {
+		t.Fatalf("got %d token requests, want 1", server.TokenRequests())
+	}
+}
+
+func TestTokenBackgroundRefresh(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	// 第一次获取成功, 之后的刷新都失败
+	server.OnToken(fengchaotest.Reply{}, fengchaotest.Reply{HTTPStatus: http.StatusInternalServerError, Msg: "token service unavailable"})
+
+	// 刷新窗口大于token的有效期, 每次请求都会在后台刷新
+	client := server.Client(fengchao.WithTokenRefreshWindow(time.Duration(fengchao.ExpiresTime)*time.Second + time.Minute))
+	ctx := context.Background()
+	if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 后台刷新不会阻塞请求, 失败时继续使用仍然有效的token
+			if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for server.TokenRequests() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("token was not refreshed in background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// 等待后台刷新结束, 之后的请求在间隔内不再刷新
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+			t.Fatalf("failed refresh wiped the valid token: %v", err)
+		}
+	}
+	if len(server.Requests()) != 16 {
+		t.Fatalf("got %d chat requests, want 16", len(server.Requests()))
+	}
+	if server.TokenRequests() != 2 {
+		t.Fatalf("got %d token requests, want 2 within the retry interval", server.TokenRequests())
+	}
+}
+
+func TestTokenRefreshFailure(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnToken(fengchaotest.Reply{Status: 401, Msg: "invalid api key", Latency: 50 * time.Millisecond}, fengchaotest.Reply{})
+
+	client := server.Client(fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}))
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	// 等待同一次刷新的请求得到同样的错误
+	for err := range errs {
+		if err == nil {
+			t.Fatal("want auth error")
+		}
+	}
+	if server.TokenRequests() != 1 {
+		t.Fatalf("got %d token requests, want 1", server.TokenRequests())
+	}
+
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenExpiresIn(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.TokenExpiresIn = 1
+
+	client := server.Client(fengchao.WithTokenRefreshWindow(0))
+	ctx := context.Background()
+	if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+	if server.TokenRequests() != 1 {
+		t.Fatalf("got %d token requests, want 1", server.TokenRequests())
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+	if server.TokenRequests() != 2 {
+		t.Fatalf("got %d token requests, want 2 after the token expired", server.TokenRequests())
+	}
+}
+
+func TestTokenRevoked(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+
+	client := server.Client(fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}))
+	ctx := context.Background()
+	if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := []func() error{
+		func() error {
+			_, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好"))
+			return err
+		},
+		func() error {
+			_, err := client.QuickCompletion(ctx, fengchao.WithPredefinedPrompts("多译英"), fengchao.WithQuery("你好"))
+			return err
+		},
+		func() error {
+			reader, err := client.ChatCompletionStream(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好"))
+			if err != nil {
+				return err
+			}
+			return reader.Close()
+		},
+	}
+	for i, call := range calls {
+		server.RevokeTokens()
+		// token被拒绝后刷新并重新发送请求
+		if err := call(); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if server.TokenRequests() != i+2 {
+			t.Fatalf("call %d: got %d token requests, want %d", i, server.TokenRequests(), i+2)
+		}
+	}
+	if len(server.Requests()) != 1+2*len(calls) {
+		t.Fatalf("got %d chat requests, want %d", len(server.Requests()), 1+2*len(calls))
+	}
+}
+
+func TestTokenRejectedTwice(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{Status: http.StatusUnauthorized, Msg: "token expired"})
+
+	client := server.Client(fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}))
+	_, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"))
+	if !errors.Is(err, fengchao.ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+	// 只重新发送一次
+	if server.TokenRequests() != 2 || len(server.Requests()) != 2 {
+		t.Fatalf("got %d token requests and %d chat requests, want 2 and 2", server.TokenRequests(), len(server.Requests()))
+	}
+}
+
+func TestTokenCallerCancel(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnToken(fengchaotest.Reply{Latency: 500 * time.Millisecond})
+	client := server.Client()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好"))
+	if err == nil || errors.Is(err, fengchao.ErrAuth) {
+		t.Fatalf("got %v, want a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Fatalf("waited %s for the token refresh after the caller gave up", elapsed)
+	}
+	// 取消不是鉴权失败, 凭证不会暂停使用, 刷新在后台继续完成
+	if usage := client.CredentialUsage()[0]; !usage.DisabledUntil.IsZero() {
+		t.Fatalf("credential disabled after caller cancel: %v", usage.LastError)
+	}
+	if err := client.Authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if server.TokenRequests() != 1 {
+		t.Fatalf("got %d token requests, want 1", server.TokenRequests())
+	}
+}
+
+func TestControlPlaneTimeout(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnToken(fengchaotest.Reply{Latency: 500 * time.Millisecond})
+	client := server.Client(fengchao.WithControlPlaneTimeout(50 * time.Millisecond))
+
+	start := time.Now()
+	err := client.Authenticate(context.Background())
+	if !errors.Is(err, fengchao.ErrAuth) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want auth error with deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Fatalf("token refresh took %s, want control plane timeout", elapsed)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	client := server.Client(fengchao.WithCredentials(fengchao.Credential{ApiKey: "second", SecretKey: "secret"}))
+
+	if err := client.Authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if server.TokenRequests() != 2 {
+		t.Fatalf("got %d token requests, want one per credential", server.TokenRequests())
+	}
+
+	server.OnToken(fengchaotest.Reply{Status: 401, Msg: "invalid api key"})
+	client = server.Client()
+	if err := client.Authenticate(context.Background()); !errors.Is(err, fengchao.ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+}
+
+func TestRefreshModels(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	if err := client.RefreshModels(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if models := client.GetAvailableModels(); len(models) != len(fengchaotest.DefaultModels) {
+		t.Fatalf("got %d models, want %d", len(models), len(fengchaotest.DefaultModels))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.RefreshModels(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	// 加载失败时保留之前的模型列表
+	if models := client.GetAvailableModels(); len(models) != len(fengchaotest.DefaultModels) {
+		t.Fatalf("got %d models after failed refresh, want %d", len(models), len(fengchaotest.DefaultModels))
+	}
+}
+
+// failingModels 模型列表请求返回503并计数的中间件
+func failingModels(count *atomic.Int32) fengchao.Middleware {
+	return func(next fengchao.Handler) fengchao.Handler {
+		return func(ctx context.Context, req *fengchao.Request) (*fengchao.Response, error) {
+			if req.Kind != fengchao.RequestModels {
+				return next(ctx, req)
+			}
+			count.Add(1)
+			return &fengchao.Response{StatusCode: http.StatusServiceUnavailable, Raw: []byte("unavailable")}, nil
+		}
+	}
+}
+
+func TestModelsFailureCached(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	var loads atomic.Int32
+	client := server.Client(fengchao.WithMiddleware(failingModels(&loads)))
+
+	// 加载失败后的一段时间内不再请求服务端
+	for range 3 {
+		if models := client.GetAvailableModels(); models != nil {
+			t.Fatalf("got %d models, want nil", len(models))
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("got %d model loads, want 1", n)
+	}
+	// 主动刷新不受限制
+	if err := client.RefreshModels(context.Background()); err == nil {
+		t.Fatal("want refresh error")
+	}
+	if n := loads.Load(); n != 2 {
+		t.Fatalf("got %d model loads, want 2", n)
+	}
+}
@@ -2,7 +2,6 @@ package fengchaogo
 
 import (
 	"context"
-	"fmt"
 	"sync"
 )
 
@@ -31,7 +30,7 @@ func NewBatchChatCompletionBuilder() *BatchChatCompletionBuilder {
 // Add 添加
 func (bccb *BatchChatCompletionBuilder) Add(prompt Prompt, params ...Option[ChatCompletion]) (*BatchChatCompletionArgs, error) {
 	if bccb.size >= BatchMaxSize {
-		return nil, fmt.Errorf("batch size exceeded")
+		return nil, ErrBatchSizeExceeded
 	}
 	arg := &BatchChatCompletionArgs{
 		Prompt: prompt,
@@ -45,28 +44,35 @@ func (bccb *BatchChatCompletionBuilder) Add(prompt Prompt, params ...Option[Chat
 
 // BatchChatCompletion 批量请求
 func (f *FengChao) BatchChatCompletion(ctx context.Context, bccb *BatchChatCompletionBuilder) (map[*BatchChatCompletionArgs]*ChatCompletionResult, map[*BatchChatCompletionArgs]error, bool) {
+	ctx, span := f.startSpan(ctx, requestBatch, nil)
+	defer span.End()
+	span.SetAttributes(attrBatchSize.Int(len(bccb.Args)))
+
 	completions := make(map[*BatchChatCompletionArgs]*ChatCompletionResult, len(bccb.Args))
 	errors := make(map[*BatchChatCompletionArgs]error, len(bccb.Args))
 	wg := new(sync.WaitGroup)
+	mu := new(sync.Mutex)
 	var commplete = true
 	for _, arg := range bccb.Args {
 
 		wg.Add(1)
 		go func(cca *BatchChatCompletionArgs) {
+			defer wg.Done()
 			prompt := cca.Prompt
 			params := cca.Params
 			completion, err := f.ChatCompletion(ctx, prompt, params...)
+			mu.Lock()
+			defer mu.Unlock()
 			if err != nil {
-				wg.Done()
 				errors[cca] = err
 				commplete = false
 				return
 			}
 			completions[cca] = completion
-			wg.Done()
 		}(arg)
 	}
 	wg.Wait()
+	span.SetAttributes(attrBatchFailed.Int(len(errors)))
 
 	return completions, errors, commplete
 }
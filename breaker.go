@@ -0,0 +1,278 @@
+package fengchaogo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState 熔断器状态
+type CircuitState int
+
+const (
+	// CircuitClosed 关闭状态, 请求正常通过
+	CircuitClosed CircuitState = iota
+	// CircuitOpen 打开状态, 请求直接失败
+	CircuitOpen
+	// CircuitHalfOpen 半开状态, 允许少量请求探测模型是否恢复
+	CircuitHalfOpen
+)
+
+// String 状态名称
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// ErrCircuitOpen 熔断器打开时返回的错误, 可以使用errors.Is判断
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError 熔断器打开时返回的错误, 包含模型和恢复探测的时间
+type CircuitOpenError struct {
+	Model   string
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open for model %s, retry at %s", e.Model, e.RetryAt.Format(time.RFC3339))
+}
+
+// Is 支持errors.Is(err, ErrCircuitOpen)
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreakerConfig 熔断器配置
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures 连续失败达到次数后熔断, 为0时不按连续失败判断
+	ConsecutiveFailures int
+	// FailureRate 统计窗口内错误率达到后熔断, 取值范围(0, 1], 为0时不按错误率判断
+	FailureRate float64
+	// MinRequests 统计窗口内请求数达到后才按错误率判断
+	MinRequests int
+	// Window 错误率的统计窗口
+	Window time.Duration
+	// OpenTimeout 熔断后多久进入半开状态
+	OpenTimeout time.Duration
+	// HalfOpenRequests 半开状态下允许的探测请求数, 全部成功后关闭熔断器
+	HalfOpenRequests int
+	// IsFailure 判断错误是否计为失败, 为空时使用IsRetryable, 参数错误等不会计为失败, 调用方取消的请求不计入统计
+	IsFailure func(err error) bool
+	// OnStateChange 状态变化的回调, 在释放熔断器的锁之后调用, 可以在回调中调用State
+	OnStateChange func(model string, from CircuitState, to CircuitState)
+}
+
+// DefaultCircuitBreakerConfig 默认的熔断器配置
+var DefaultCircuitBreakerConfig = CircuitBreakerConfig{
+	ConsecutiveFailures: 5,
+	FailureRate:         0.5,
+	MinRequests:         20,
+	Window:              time.Minute,
+	OpenTimeout:         30 * time.Second,
+	HalfOpenRequests:    1,
+}
+
+// CircuitBreaker 按照模型分别熔断的熔断器
+type CircuitBreaker struct {
+	config   CircuitBreakerConfig
+	circuits map[string]*circuit
+	mu       sync.Mutex
+	// changes 持有锁期间发生的状态变化, 释放锁之后调用回调
+	changes []stateChange
+}
+
+// stateChange 状态变化
+type stateChange struct {
+	model    string
+	from, to CircuitState
+}
+
+// circuit 单个模型的熔断状态
+type circuit struct {
+	state       CircuitState
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	probes      int
+	successes   int
+}
+
+// NewCircuitBreaker 创建熔断器
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	return &CircuitBreaker{
+		config:   config,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// State 获取模型当前的熔断状态
+func (b *CircuitBreaker) State(model string) CircuitState {
+	b.mu.Lock()
+	defer b.unlock()
+	c, ok := b.circuits[model]
+	if !ok {
+		return CircuitClosed
+	}
+	b.refresh(model, c, time.Now())
+	return c.state
+}
+
+// circuit 获取模型的熔断状态
+func (b *CircuitBreaker) circuit(model string) *circuit {
+	c, ok := b.circuits[model]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.circuits[model] = c
+	}
+	return c
+}
+
+// transition 切换状态
+func (b *CircuitBreaker) transition(model string, c *circuit, to CircuitState, now time.Time) {
+	from := c.state
+	if from == to {
+		return
+	}
+	c.state = to
+	c.requests, c.failures, c.consecutive, c.probes, c.successes = 0, 0, 0, 0, 0
+	c.windowStart = now
+	if to == CircuitOpen {
+		c.openedAt = now
+	}
+	if b.config.OnStateChange != nil {
+		b.changes = append(b.changes, stateChange{model: model, from: from, to: to})
+	}
+}
+
+// unlock 释放锁, 之后按照顺序调用状态变化的回调, 避免回调中访问熔断器时死锁
+func (b *CircuitBreaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	for _, change := range changes {
+		b.config.OnStateChange(change.model, change.from, change.to)
+	}
+}
+
+// refresh 打开状态超时后进入半开状态, 并滚动统计窗口
+func (b *CircuitBreaker) refresh(model string, c *circuit, now time.Time) {
+	if c.state == CircuitOpen && now.Sub(c.openedAt) >= b.config.OpenTimeout {
+		b.transition(model, c, CircuitHalfOpen, now)
+	}
+	if c.state == CircuitClosed && b.config.Window > 0 && now.Sub(c.windowStart) >= b.config.Window {
+		c.requests, c.failures = 0, 0
+		c.windowStart = now
+	}
+}
+
+// Allow 判断模型是否允许发送请求, 允许时返回记录请求结果的函数
+func (b *CircuitBreaker) Allow(model string) (func(err error), error) {
+	b.mu.Lock()
+	defer b.unlock()
+	now := time.Now()
+	c := b.circuit(model)
+	b.refresh(model, c, now)
+
+	switch c.state {
+	case CircuitOpen:
+		return nil, &CircuitOpenError{Model: model, RetryAt: c.openedAt.Add(b.config.OpenTimeout)}
+	case CircuitHalfOpen:
+		if c.probes >= b.config.HalfOpenRequests {
+			return nil, &CircuitOpenError{Model: model, RetryAt: now}
+		}
+		c.probes++
+	}
+
+	state := c.state
+	return func(err error) {
+		b.record(model, state, err)
+	}, nil
+}
+
+// isFailure 判断错误是否计为失败
+func (b *CircuitBreaker) isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if b.config.IsFailure != nil {
+		return b.config.IsFailure(err)
+	}
+	return IsRetryable(err)
+}
+
+// record 记录请求结果, 调用方取消的请求不计入结果, 半开状态下归还探测名额
+func (b *CircuitBreaker) record(model string, state CircuitState, err error) {
+	canceled := errors.Is(err, context.Canceled)
+	// IsFailure为调用方的函数, 在锁外调用
+	failed := !canceled && b.isFailure(err)
+	b.mu.Lock()
+	defer b.unlock()
+	now := time.Now()
+	c := b.circuit(model)
+	// 状态已经变化, 旧状态下的请求结果不再统计
+	if c.state != state {
+		return
+	}
+	if canceled {
+		if c.state == CircuitHalfOpen && c.probes > 0 {
+			c.probes--
+		}
+		return
+	}
+
+	if c.state == CircuitHalfOpen {
+		if failed {
+			b.transition(model, c, CircuitOpen, now)
+			return
+		}
+		c.successes++
+		if c.successes >= b.config.HalfOpenRequests {
+			b.transition(model, c, CircuitClosed, now)
+		}
+		return
+	}
+
+	c.requests++
+	if !failed {
+		c.consecutive = 0
+		return
+	}
+	c.failures++
+	c.consecutive++
+
+	if b.config.ConsecutiveFailures > 0 && c.consecutive >= b.config.ConsecutiveFailures {
+		b.transition(model, c, CircuitOpen, now)
+		return
+	}
+	if b.config.FailureRate > 0 && c.requests >= b.config.MinRequests &&
+		float64(c.failures)/float64(c.requests) >= b.config.FailureRate {
+		b.transition(model, c, CircuitOpen, now)
+	}
+}
+
+// allowCircuit 检查模型的熔断状态, 返回记录请求结果的函数
+func (f *FengChao) allowCircuit(params *ChatCompletion) (func(err error), error) {
+	if f.breaker == nil {
+		return func(error) {}, nil
+	}
+	done, err := f.breaker.Allow(params.Model)
+	if err != nil {
+		f.logger.Warn("fengchao circuit breaker rejected", "request_id", params.RequestID, "model", params.Model, "error", err)
+		return nil, err
+	}
+	return done, nil
+}
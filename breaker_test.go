@@ -0,0 +1,225 @@
+package fengchaogo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []string
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         20 * time.Millisecond,
+		OnStateChange: func(model string, from, to CircuitState) {
+			changes = append(changes, fmt.Sprintf("%s:%s->%s", model, from, to))
+		},
+	})
+	serverError := newAPIError(http.StatusBadGateway, 0, "bad gateway", "", nil)
+	badRequest := newAPIError(http.StatusBadRequest, 0, "bad request", "", nil)
+
+	for _, err := range []error{badRequest, serverError, serverError} {
+		done, allowErr := breaker.Allow("glm-4")
+		if allowErr != nil {
+			t.Fatalf("Allow() error = %v", allowErr)
+		}
+		done(err)
+	}
+	if got := breaker.State("glm-4"); got != CircuitOpen {
+		t.Fatalf("State() = %v, want %v", got, CircuitOpen)
+	}
+
+	_, err := breaker.Allow("glm-4")
+	var openErr *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &openErr) || openErr.Model != "glm-4" {
+		t.Fatalf("Allow() error = %v, want CircuitOpenError", err)
+	}
+	// 其他模型不受影响
+	if _, err := breaker.Allow("gpt-4o"); err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if got := breaker.State("glm-4"); got != CircuitHalfOpen {
+		t.Fatalf("State() = %v, want %v", got, CircuitHalfOpen)
+	}
+	done, err := breaker.Allow("glm-4")
+	if err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+	if _, err := breaker.Allow("glm-4"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() error = %v, want %v", err, ErrCircuitOpen)
+	}
+	done(nil)
+
+	if got := breaker.State("glm-4"); got != CircuitClosed {
+		t.Fatalf("State() = %v, want %v", got, CircuitClosed)
+	}
+	want := []string{"glm-4:closed->open", "glm-4:open->half-open", "glm-4:half-open->closed"}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("state changes = %v, want %v", changes, want)
+	}
+}
+
+func TestCircuitBreaker_FailureRate(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{
+		FailureRate: 0.5,
+		MinRequests: 4,
+		Window:      time.Minute,
+		OpenTimeout: time.Minute,
+	})
+	serverError := newAPIError(http.StatusInternalServerError, 0, "internal error", "", nil)
+
+	for _, err := range []error{nil, serverError, nil, serverError} {
+		done, allowErr := breaker.Allow("glm-4")
+		if allowErr != nil {
+			t.Fatalf("Allow() error = %v", allowErr)
+		}
+		done(err)
+	}
+	if got := breaker.State("glm-4"); got != CircuitOpen {
+		t.Errorf("State() = %v, want %v", got, CircuitOpen)
+	}
+}
+
+func TestCircuitBreakerCallbackReentrant(t *testing.T) {
+	var breaker *CircuitBreaker
+	states := make(chan CircuitState, 1)
+	breaker = NewCircuitBreaker(CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Minute,
+		OnStateChange: func(model string, from, to CircuitState) {
+			// 回调中访问熔断器不会死锁
+			states <- breaker.State(model)
+		},
+	})
+	done, err := breaker.Allow("glm-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished := make(chan struct{})
+	go func() {
+		done(newAPIError(http.StatusBadGateway, 0, "bad gateway", "", nil))
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("OnStateChange deadlocked")
+	}
+	if got := <-states; got != CircuitOpen {
+		t.Fatalf("State() in callback = %v, want %v", got, CircuitOpen)
+	}
+}
+
+func TestFengChao_CircuitBreaker(t *testing.T) {
+	var failing, slow atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"status": 200, "token": "token"})
+			return
+		}
+		var params ChatCompletion
+		json.NewDecoder(r.Body).Decode(&params)
+		requests.Add(1)
+		if slow.Load() {
+			<-r.Context().Done()
+			return
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"detail":"busy"}`)
+			return
+		}
+		if params.Mode == StreamMode {
+			fmt.Fprint(w, "event: add\ndata: {\"status\":200,\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":\"hi\"}}]}\n\nevent: stop\ndata: {\"status\":200}\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":  200,
+			"choices": []map[string]any{{"message": map[string]any{"role": RoleAssistant, "content": "hi"}}},
+		})
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 2, OpenTimeout: 50 * time.Millisecond})
+	client := NewFengChao("key", "secret", server.URL, WithCircuitBreaker(breaker))
+	ctx := context.Background()
+	batch := func() map[*BatchChatCompletionArgs]error {
+		builder := NewBatchChatCompletionBuilder()
+		for range 2 {
+			builder.Add(NewUserMessage("hello"), WithModel("glm-4"))
+		}
+		_, errs, _ := client.BatchChatCompletion(ctx, builder)
+		return errs
+	}
+
+	// 对话和流式对话的失败都计入熔断器
+	failing.Store(true)
+	if _, err := client.ChatCompletion(ctx, NewUserMessage("hello"), WithModel("glm-4")); err == nil {
+		t.Fatal("ChatCompletion() error = nil, want error")
+	}
+	if _, err := client.ChatCompletionStream(ctx, NewUserMessage("hello"), WithModel("glm-4")); err == nil {
+		t.Fatal("ChatCompletionStream() error = nil, want error")
+	}
+	if got := breaker.State("glm-4"); got != CircuitOpen {
+		t.Fatalf("State() = %v, want %v", got, CircuitOpen)
+	}
+
+	// 熔断时批量请求的每个请求都直接失败, 不发送请求
+	sent := requests.Load()
+	errs := batch()
+	if len(errs) != 2 {
+		t.Fatalf("BatchChatCompletion() errors = %v, want 2", errs)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("BatchChatCompletion() error = %v, want ErrCircuitOpen", err)
+		}
+	}
+	if _, err := client.ChatCompletionStream(ctx, NewUserMessage("hello"), WithModel("glm-4")); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("ChatCompletionStream() error = %v, want ErrCircuitOpen", err)
+	}
+	if got := requests.Load(); got != sent {
+		t.Fatalf("requests = %d, want %d", got, sent)
+	}
+
+	// 半开状态下调用方取消的探测请求不关闭熔断器
+	time.Sleep(60 * time.Millisecond)
+	failing.Store(false)
+	slow.Store(true)
+	canceled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := client.ChatCompletion(canceled, NewUserMessage("hello"), WithModel("glm-4")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ChatCompletion() error = %v, want context.Canceled", err)
+	}
+	if got := breaker.State("glm-4"); got != CircuitHalfOpen {
+		t.Fatalf("State() = %v, want %v", got, CircuitHalfOpen)
+	}
+
+	// 探测成功后关闭熔断器
+	slow.Store(false)
+	reader, err := client.ChatCompletionStream(ctx, NewUserMessage("hello"), WithModel("glm-4"))
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	if _, _, err := reader.Read(); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	reader.Close()
+	if got := breaker.State("glm-4"); got != CircuitClosed {
+		t.Fatalf("State() = %v, want %v", got, CircuitClosed)
+	}
+	if errs := batch(); len(errs) != 0 {
+		t.Fatalf("BatchChatCompletion() errors = %v", errs)
+	}
+}
@@ -0,0 +1,173 @@
+// Package cassette 提供录制和回放HTTP交互的Transport, 用于在没有网络的环境中进行确定性的测试
+//
+// 录制时使用Recorder包装真实的Transport, 会记录/token、/models/和/chat/的请求和响应(包括流式响应的事件时间),
+// 并对密钥和token进行脱敏; 回放时使用Replayer作为客户端的Transport, 按照去掉易变字段后的请求内容进行匹配
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Redacted 脱敏后的占位符
+const Redacted = "REDACTED"
+
+// 默认需要脱敏和忽略的字段
+var (
+	// DefaultScrubQuery 需要脱敏的请求参数
+	DefaultScrubQuery = []string{"api_key", "secret_key"}
+	// DefaultScrubHeaders 需要脱敏的请求头和响应头
+	DefaultScrubHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	// DefaultScrubBodyFields 需要脱敏的响应体字段
+	DefaultScrubBodyFields = []string{"token"}
+	// DefaultVolatileFields 匹配请求时忽略的请求体字段
+	DefaultVolatileFields = []string{"request_id"}
+)
+
+// Cassette 录制的HTTP交互
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+
+	mu sync.Mutex
+}
+
+// Interaction 一次请求和响应
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request 录制的请求
+type Request struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Query  url.Values  `json:"query,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response 录制的响应, 流式响应的内容记录在Events中
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	Events     []Event     `json:"events,omitempty"`
+}
+
+// Event 流式响应的一段数据
+type Event struct {
+	// OffsetMs 相对于响应开始的时间(毫秒)
+	OffsetMs int64 `json:"offset_ms"`
+	// Data 数据内容
+	Data string `json:"data"`
+}
+
+// Load 从文件加载录制的交互
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load cassette error: %w", err)
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("unmarshal cassette error: %w", err)
+	}
+	return c, nil
+}
+
+// Save 保存录制的交互到文件
+func (c *Cassette) Save(path string) error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("marshal cassette error: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("save cassette error: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("save cassette error: %w", err)
+	}
+	return nil
+}
+
+// add 添加一次交互
+func (c *Cassette) add(interaction *Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, interaction)
+}
+
+// scrubQuery 对请求参数进行脱敏
+func scrubQuery(query url.Values, keys []string) url.Values {
+	scrubbed := make(url.Values, len(query))
+	for k, v := range query {
+		scrubbed[k] = append([]string(nil), v...)
+	}
+	for _, key := range keys {
+		if _, ok := scrubbed[key]; ok {
+			scrubbed[key] = []string{Redacted}
+		}
+	}
+	return scrubbed
+}
+
+// scrubHeader 对请求头和响应头进行脱敏
+func scrubHeader(header http.Header, keys []string) http.Header {
+	scrubbed := header.Clone()
+	for _, key := range keys {
+		if scrubbed.Get(key) != "" {
+			scrubbed.Set(key, Redacted)
+		}
+	}
+	return scrubbed
+}
+
+// scrubBody 对json响应体中的字段进行脱敏, 不是json对象时原样返回
+func scrubBody(body []byte, fields []string) []byte {
+	var object map[string]any
+	if len(fields) == 0 || json.Unmarshal(body, &object) != nil {
+		return body
+	}
+	changed := false
+	for _, field := range fields {
+		if _, ok := object[field]; ok {
+			object[field] = Redacted
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	scrubbed, err := json.Marshal(object)
+	if err != nil {
+		return body
+	}
+	return scrubbed
+}
+
+// normalizeBody 去掉易变字段后重新编码请求体, 用于匹配请求
+func normalizeBody(body string, volatile []string) string {
+	var object map[string]any
+	if json.Unmarshal([]byte(body), &object) != nil {
+		return strings.TrimSpace(body)
+	}
+	for _, field := range volatile {
+		delete(object, field)
+	}
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(object); err != nil {
+		return strings.TrimSpace(body)
+	}
+	return strings.TrimSpace(buffer.String())
+}
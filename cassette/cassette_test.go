@@ -0,0 +1,110 @@
+package cassette
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+)
+
+const (
+	testApiKey    = "api-key-1234567890"
+	testSecretKey = "secret-key-1234567890"
+	testToken     = "token-1234567890"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"status": 200, "token": testToken})
+		case "/chat/":
+			var params fengchao.ChatCompletion
+			json.NewDecoder(r.Body).Decode(&params)
+			if params.Mode == fengchao.StreamMode {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, content := range []string{"你", "好"} {
+					fmt.Fprintf(w, "event: add\ndata: {\"status\":200,\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":%q}}]}\n\n", content)
+					w.(http.Flusher).Flush()
+				}
+				fmt.Fprint(w, "event: stop\ndata: {\"status\":200}\n\n")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"request_id": params.RequestID,
+				"status":     200,
+				"choices":    []map[string]any{{"message": map[string]any{"role": fengchao.RoleAssistant, "content": "echo: " + params.Query}}},
+			})
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+}
+
+// exercise 执行一次对话和一次流式对话
+func exercise(t *testing.T, client *fengchao.FengChao) (string, string) {
+	res, err := client.ChatCompletion(context.Background(), fengchao.NewUserMessage("hello"), fengchao.WithModel("glm-4"))
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	reader, err := client.ChatCompletionStream(context.Background(), fengchao.NewUserMessage("hi"), fengchao.WithModel("glm-4"))
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	streamed := ""
+	for chunk := range reader.Stream() {
+		streamed += chunk.String()
+	}
+	return res.String(), streamed
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "chat.json")
+
+	recorder := NewRecorder(nil)
+	recorded, recordedStream := exercise(t, fengchao.NewFengChao(testApiKey, testSecretKey, server.URL, fengchao.WithTransport(recorder)))
+	if err := recorder.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	server.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	for _, secret := range []string{testApiKey, testSecretKey, testToken} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains secret %q", secret)
+		}
+	}
+
+	replayer, err := LoadReplayer(path)
+	if err != nil {
+		t.Fatalf("LoadReplayer() error = %v", err)
+	}
+	// 使用其他的密钥和地址回放, 请求ID也会重新生成
+	replayed, replayedStream := exercise(t, fengchao.NewFengChao("other-key", "other-secret", "http://fengchao.invalid", fengchao.WithTransport(replayer)))
+
+	if recorded != "echo: hello" || replayed != recorded {
+		t.Errorf("ChatCompletion() recorded = %q, replayed = %q", recorded, replayed)
+	}
+	if recordedStream != "你好" || replayedStream != recordedStream {
+		t.Errorf("ChatCompletionStream() recorded = %q, replayed = %q", recordedStream, replayedStream)
+	}
+
+	_, err = fengchao.NewFengChao("key", "secret", "http://fengchao.invalid", fengchao.WithTransport(replayer)).
+		ChatCompletion(context.Background(), fengchao.NewUserMessage("unknown"), fengchao.WithModel("glm-4"))
+	if err == nil || !strings.Contains(err.Error(), "no interaction matches") {
+		t.Errorf("ChatCompletion() error = %v, want no interaction matches", err)
+	}
+}
@@ -0,0 +1,146 @@
+package cassette
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Recorder 录制HTTP交互的Transport
+type Recorder struct {
+	// ScrubQuery 需要脱敏的请求参数
+	ScrubQuery []string
+	// ScrubHeaders 需要脱敏的请求头和响应头
+	ScrubHeaders []string
+	// ScrubBodyFields 需要脱敏的响应体字段
+	ScrubBodyFields []string
+
+	next     http.RoundTripper
+	cassette *Cassette
+}
+
+var _ http.RoundTripper = (*Recorder)(nil)
+
+// NewRecorder 创建录制的Transport, next为空时使用http.DefaultTransport
+func NewRecorder(next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{
+		ScrubQuery:      DefaultScrubQuery,
+		ScrubHeaders:    DefaultScrubHeaders,
+		ScrubBodyFields: DefaultScrubBodyFields,
+		next:            next,
+		cassette:        &Cassette{},
+	}
+}
+
+// Cassette 获取录制的交互
+func (r *Recorder) Cassette() *Cassette {
+	return r.cassette
+}
+
+// Save 保存录制的交互到文件, 流式响应需要在数据流关闭之后才会被保存
+func (r *Recorder) Save(path string) error {
+	return r.cassette.Save(path)
+}
+
+// RoundTrip 发送请求并录制请求和响应
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, fmt.Errorf("read request body error: %w", err)
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := &Interaction{
+		Request: Request{
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Query:  scrubQuery(req.URL.Query(), r.ScrubQuery),
+			Header: scrubHeader(req.Header, r.ScrubHeaders),
+			Body:   string(body),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     scrubHeader(resp.Header, r.ScrubHeaders),
+		},
+	}
+
+	if isEventStream(resp) {
+		resp.Body = &eventRecorder{
+			body:        resp.Body,
+			start:       time.Now(),
+			interaction: interaction,
+			cassette:    r.cassette,
+		}
+		return resp, nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response body error: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	interaction.Response.Body = string(scrubBody(data, r.ScrubBodyFields))
+	r.cassette.add(interaction)
+	return resp, nil
+}
+
+// isEventStream 判断是否为流式响应
+func isEventStream(resp *http.Response) bool {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return true
+	}
+	return resp.StatusCode == http.StatusOK && resp.ContentLength < 0 && !strings.Contains(resp.Header.Get("Content-Type"), "json")
+}
+
+// eventRecorder 录制流式响应的数据和时间, 关闭时保存交互
+type eventRecorder struct {
+	body        io.ReadCloser
+	start       time.Time
+	interaction *Interaction
+	cassette    *Cassette
+	saved       bool
+}
+
+func (e *eventRecorder) Read(p []byte) (int, error) {
+	n, err := e.body.Read(p)
+	if n > 0 {
+		e.interaction.Response.Events = append(e.interaction.Response.Events, Event{
+			OffsetMs: time.Since(e.start).Milliseconds(),
+			Data:     string(p[:n]),
+		})
+	}
+	if err == io.EOF {
+		e.save()
+	}
+	return n, err
+}
+
+func (e *eventRecorder) Close() error {
+	e.save()
+	return e.body.Close()
+}
+
+// save 保存交互, 只会保存一次
+func (e *eventRecorder) save() {
+	if e.saved {
+		return
+	}
+	e.saved = true
+	e.cassette.add(e.interaction)
+}
@@ -0,0 +1,167 @@
+package cassette
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replayer 回放录制的交互的Transport, 不会发送真实的请求
+type Replayer struct {
+	// VolatileFields 匹配请求时忽略的请求体字段
+	VolatileFields []string
+	// ScrubQuery 匹配请求时忽略的请求参数(录制时已经脱敏)
+	ScrubQuery []string
+	// Realtime 是否按照录制时的时间间隔回放流式响应
+	Realtime bool
+
+	cassette *Cassette
+	used     []bool
+	mu       sync.Mutex
+}
+
+var _ http.RoundTripper = (*Replayer)(nil)
+
+// NewReplayer 使用录制的交互创建回放的Transport
+func NewReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{
+		VolatileFields: DefaultVolatileFields,
+		ScrubQuery:     DefaultScrubQuery,
+		cassette:       cassette,
+		used:           make([]bool, len(cassette.Interactions)),
+	}
+}
+
+// LoadReplayer 从文件加载录制的交互并创建回放的Transport
+func LoadReplayer(path string) (*Replayer, error) {
+	cassette, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(cassette), nil
+}
+
+// RoundTrip 查找匹配的交互并返回录制的响应
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, fmt.Errorf("read request body error: %w", err)
+		}
+		req.Body.Close()
+	}
+
+	interaction := r.match(req, string(body))
+	if interaction == nil {
+		return nil, fmt.Errorf("cassette: no interaction matches %s %s", req.Method, req.URL.Path)
+	}
+
+	recorded := interaction.Response
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header.Clone(),
+		Request:       req,
+		ContentLength: -1,
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+
+	if len(recorded.Events) > 0 {
+		resp.Body = &eventReplayer{events: recorded.Events, realtime: r.Realtime, start: time.Now(), req: req}
+	} else {
+		resp.Body = io.NopCloser(strings.NewReader(recorded.Body))
+		resp.ContentLength = int64(len(recorded.Body))
+	}
+	return resp, nil
+}
+
+// match 查找匹配的交互, 优先使用还没有回放过的交互
+func (r *Replayer) match(req *http.Request, body string) *Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	query := scrubQuery(req.URL.Query(), r.ScrubQuery)
+	normalized := normalizeBody(body, r.VolatileFields)
+	last := -1
+	for i, interaction := range r.cassette.Interactions {
+		recorded := interaction.Request
+		if recorded.Method != req.Method || recorded.Path != req.URL.Path ||
+			!equalQuery(recorded.Query, query) ||
+			normalizeBody(recorded.Body, r.VolatileFields) != normalized {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return interaction
+		}
+		last = i
+	}
+	if last >= 0 {
+		return r.cassette.Interactions[last]
+	}
+	return nil
+}
+
+// equalQuery 比较请求参数
+func equalQuery(a url.Values, b url.Values) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if !slices.Equal(v, b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// eventReplayer 回放流式响应
+type eventReplayer struct {
+	events   []Event
+	realtime bool
+	start    time.Time
+	req      *http.Request
+	buffer   bytes.Buffer
+}
+
+func (e *eventReplayer) Read(p []byte) (int, error) {
+	for e.buffer.Len() == 0 {
+		if len(e.events) == 0 {
+			return 0, io.EOF
+		}
+		event := e.events[0]
+		e.events = e.events[1:]
+		if e.realtime {
+			wait := time.Until(e.start.Add(time.Duration(event.OffsetMs) * time.Millisecond))
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-e.req.Context().Done():
+					timer.Stop()
+					return 0, e.req.Context().Err()
+				case <-timer.C:
+				}
+			}
+		}
+		e.buffer.WriteString(event.Data)
+	}
+	return e.buffer.Read(p)
+}
+
+func (e *eventReplayer) Close() error {
+	e.events = nil
+	e.buffer.Reset()
+	return nil
+}
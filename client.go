@@ -1,13 +1,24 @@
 package fengchaogo
 
 import (
+	"fmt"
+	"net/http"
 	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const BasicRequestTimeout int = 3
 
+// DefaultControlPlaneTimeout 默认的控制面请求(获取token、加载模型)超时时间
+const DefaultControlPlaneTimeout = time.Duration(BasicRequestTimeout) * time.Second
+
+// DefaultUserAgent 默认的User-Agent
+const DefaultUserAgent = "fengchao-go"
+
 // FengChaoOptions 配置
 type FengChao struct {
 	// ApiKey fengchao api key
@@ -20,8 +31,60 @@ type FengChao struct {
 	// client http client
 	client *resty.Client
 
-	// authToken 认证令牌
-	auth *authManager
+	// httpClient 自定义的http客户端
+	httpClient *http.Client
+	// transport 自定义的传输层
+	transport http.RoundTripper
+	// proxy 代理地址
+	proxy string
+	// headers 默认请求头
+	headers map[string]string
+	// userAgent 请求的User-Agent
+	userAgent string
+	// pool 连接池配置
+	pool *connectionPool
+
+	// chatOptions 客户端默认的对话配置, 在DefaultChatCompletionOption之后、请求的配置之前生效
+	chatOptions []Option[ChatCompletion]
+
+	// logger 结构化日志
+	logger Logger
+	// debug 是否输出请求和响应的详细内容
+	debug bool
+
+	// retry 默认的重试策略
+	retry *RetryPolicy
+	// limiter 客户端限流器
+	limiter *RateLimiter
+	// breaker 模型熔断器
+	breaker *CircuitBreaker
+	// middlewares 请求中间件
+	middlewares []Middleware
+	// tracer 链路追踪
+	tracer trace.Tracer
+	// propagator 链路信息的传播方式
+	propagator propagation.TextMapPropagator
+	// metrics 指标收集
+	metrics Metrics
+
+	// credentialList 额外的鉴权凭证
+	credentialList []Credential
+	// credentialConfig 凭证池配置
+	credentialConfig CredentialPoolConfig
+	// credentials 凭证池
+	credentials *credentialPool
+
+	// tokenRefreshWindow token过期前多久开始后台刷新
+	tokenRefreshWindow time.Duration
+	// controlPlaneTimeout 获取token、加载模型等控制面请求的超时时间
+	controlPlaneTimeout time.Duration
+
+	// endpointList 额外的服务地址
+	endpointList []Endpoint
+	// healthCheck 不可用服务地址的健康检查配置
+	healthCheck HealthCheck
+	// endpoints 服务地址池, 每个服务地址单独维护token
+	endpoints *endpointPool
 
 	// availableModels 可用模型
 	availableModels *modelsManager
@@ -29,21 +92,112 @@ type FengChao struct {
 	sync.Mutex
 }
 
-func NewFengChao(apiKey string, secretKey string, baseUrl string) *FengChao {
+// connectionPool 连接池配置
+type connectionPool struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+}
+
+// NewFengChao 创建客户端, 可以通过Option[FengChao]对客户端进行配置
+func NewFengChao(apiKey string, secretKey string, baseUrl string, options ...Option[FengChao]) *FengChao {
 	fengChao := &FengChao{
-		ApiKey:    apiKey,
-		SecretKey: secretKey,
-		BaseUrl:   baseUrl,
+		ApiKey:              apiKey,
+		SecretKey:           secretKey,
+		BaseUrl:             baseUrl,
+		userAgent:           DefaultUserAgent,
+		logger:              nopLogger{},
+		tracer:              defaultTracer,
+		propagator:          propagation.TraceContext{},
+		metrics:             nopMetrics{},
+		credentialConfig:    DefaultCredentialPoolConfig,
+		tokenRefreshWindow:  DefaultTokenRefreshWindow,
+		controlPlaneTimeout: DefaultControlPlaneTimeout,
+		healthCheck:         DefaultHealthCheck,
+		availableModels:     &modelsManager{},
 	}
 
-	client := resty.New().
-		SetBaseURL(fengChao.BaseUrl).
-		SetDebug(false)
-	fengChao.client = client
+	for _, option := range options {
+		option(fengChao)
+	}
+
+	credentials := fengChao.credentialList
+	if apiKey != "" || len(credentials) == 0 {
+		credentials = append([]Credential{{ApiKey: apiKey, SecretKey: secretKey}}, credentials...)
+	}
+	fengChao.credentials = newCredentialPool(fengChao.credentialConfig, credentials)
+	fengChao.endpoints = newEndpointPool(baseUrl, fengChao.endpointList, fengChao.healthCheck)
+	if fengChao.BaseUrl == "" && len(fengChao.endpoints.endpoints) > 0 {
+		fengChao.BaseUrl = fengChao.endpoints.endpoints[0].URL
+	}
+
+	fengChao.client = fengChao.newRestyClient()
 	return fengChao
 }
 
+// Close 停止后台对不可用服务地址的健康检查并等待退出
+// 关闭后仍然可以发送请求, 不可用的服务地址只会在请求成功时恢复
+func (f *FengChao) Close() error {
+	f.endpoints.close()
+	return nil
+}
+
+// newRestyClient 根据配置创建请求客户端
+func (f *FengChao) newRestyClient() *resty.Client {
+	var client *resty.Client
+	if f.httpClient != nil {
+		// 拷贝一份避免设置传输层时修改调用方的http客户端
+		httpClient := *f.httpClient
+		client = resty.NewWithClient(&httpClient)
+	} else {
+		client = resty.New()
+	}
+
+	if f.transport != nil {
+		client.SetTransport(f.transport)
+	}
+
+	// 连接池和代理只对*http.Transport生效, 拷贝一份避免修改调用方的Transport
+	if f.pool != nil || f.proxy != "" {
+		if transport, ok := client.GetClient().Transport.(*http.Transport); ok {
+			transport = transport.Clone()
+			client.SetTransport(transport)
+			if f.pool != nil {
+				transport.MaxIdleConns = f.pool.maxIdleConns
+				transport.MaxIdleConnsPerHost = f.pool.maxIdleConnsPerHost
+				transport.IdleConnTimeout = f.pool.idleConnTimeout
+			}
+			if f.proxy != "" {
+				client.SetProxy(f.proxy)
+			}
+		} else {
+			f.logger.Warn("fengchao proxy and connection pool are ignored for custom transport", "transport", fmt.Sprintf("%T", client.GetClient().Transport))
+		}
+	}
+
+	if len(f.headers) > 0 {
+		client.SetHeaders(f.headers)
+	}
+	if f.userAgent != "" {
+		client.SetHeader("User-Agent", f.userAgent)
+	}
+
+	return client.
+		SetBaseURL(f.BaseUrl).
+		SetDebug(false)
+}
+
+// SetDebug 开启后会通过Logger输出请求和响应的详细内容(敏感信息已脱敏)
 func (f *FengChao) SetDebug(debug bool) *FengChao {
-	f.client.SetDebug(debug)
+	f.debug = debug
+	return f
+}
+
+// SetLogger 设置日志, 为空时不输出日志
+func (f *FengChao) SetLogger(logger Logger) *FengChao {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	f.logger = logger
 	return f
 }
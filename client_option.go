@@ -0,0 +1,183 @@
+package fengchaogo
+
+import (
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithHTTPClient 设置自定义的http客户端, 使用它的拷贝, 不会修改传入的客户端
+func WithHTTPClient(client *http.Client) Option[FengChao] {
+	return func(option *FengChao) {
+		option.httpClient = client
+	}
+}
+
+// WithTransport 设置自定义的传输层, 会覆盖http客户端中的Transport
+func WithTransport(transport http.RoundTripper) Option[FengChao] {
+	return func(option *FengChao) {
+		option.transport = transport
+	}
+}
+
+// WithProxy 设置代理地址, 仅在传输层为*http.Transport时生效, 其他传输层会记录警告日志并忽略
+func WithProxy(proxy string) Option[FengChao] {
+	return func(option *FengChao) {
+		option.proxy = proxy
+	}
+}
+
+// WithHeaders 设置默认请求头, 所有请求都会携带
+func WithHeaders(headers map[string]string) Option[FengChao] {
+	return func(option *FengChao) {
+		if option.headers == nil {
+			option.headers = make(map[string]string, len(headers))
+		}
+		for k, v := range headers {
+			option.headers[k] = v
+		}
+	}
+}
+
+// WithUserAgent 设置User-Agent
+func WithUserAgent(userAgent string) Option[FengChao] {
+	return func(option *FengChao) {
+		option.userAgent = userAgent
+	}
+}
+
+// WithConnectionPool 设置连接池参数, 仅在传输层为*http.Transport时生效, 其他传输层会记录警告日志并忽略
+func WithConnectionPool(maxIdleConns, maxIdleConnsPerHost int, idleConnTimeout time.Duration) Option[FengChao] {
+	return func(option *FengChao) {
+		option.pool = &connectionPool{
+			maxIdleConns:        maxIdleConns,
+			maxIdleConnsPerHost: maxIdleConnsPerHost,
+			idleConnTimeout:     idleConnTimeout,
+		}
+	}
+}
+
+// WithChatCompletionOptions 设置客户端默认的对话配置, 例如模型、temperature和超时时间, 请求时传入的配置会覆盖默认配置
+func WithChatCompletionOptions(options ...Option[ChatCompletion]) Option[FengChao] {
+	return func(option *FengChao) {
+		option.chatOptions = append(option.chatOptions, options...)
+	}
+}
+
+// WithLogger 设置结构化日志
+func WithLogger(logger Logger) Option[FengChao] {
+	return func(option *FengChao) {
+		option.SetLogger(logger)
+	}
+}
+
+// WithDebug 设置是否输出请求和响应的详细内容
+func WithDebug(debug bool) Option[FengChao] {
+	return func(option *FengChao) {
+		option.debug = debug
+	}
+}
+
+// WithRetryPolicy 设置客户端默认的重试策略
+func WithRetryPolicy(policy *RetryPolicy) Option[FengChao] {
+	return func(option *FengChao) {
+		option.retry = policy
+	}
+}
+
+// WithRateLimiter 设置客户端限流器, 请求会阻塞直到限流器放行
+func WithRateLimiter(limiter *RateLimiter) Option[FengChao] {
+	return func(option *FengChao) {
+		option.limiter = limiter
+	}
+}
+
+// WithCircuitBreaker 设置模型熔断器, 熔断的模型会直接返回CircuitOpenError
+func WithCircuitBreaker(breaker *CircuitBreaker) Option[FengChao] {
+	return func(option *FengChao) {
+		option.breaker = breaker
+	}
+}
+
+// WithMiddleware 添加请求中间件, 先添加的中间件在外层
+func WithMiddleware(middlewares ...Middleware) Option[FengChao] {
+	return func(option *FengChao) {
+		option.Use(middlewares...)
+	}
+}
+
+// WithTracerProvider 开启OpenTelemetry链路追踪
+func WithTracerProvider(provider trace.TracerProvider) Option[FengChao] {
+	return func(option *FengChao) {
+		option.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// WithPropagator 设置链路信息在请求头中的传播方式, 默认为W3C Trace Context
+func WithPropagator(propagator propagation.TextMapPropagator) Option[FengChao] {
+	return func(option *FengChao) {
+		option.propagator = propagator
+	}
+}
+
+// WithMetrics 设置指标收集, 可以使用NewPrometheusMetrics创建Prometheus格式的指标
+func WithMetrics(metrics Metrics) Option[FengChao] {
+	return func(option *FengChao) {
+		if metrics == nil {
+			metrics = nopMetrics{}
+		}
+		option.metrics = metrics
+	}
+}
+
+// WithCredentials 添加鉴权凭证, NewFengChao的apiKey和secretKey作为第一个凭证
+// 每次请求按照凭证池的策略选择一个凭证, 每个凭证单独获取和缓存token
+func WithCredentials(credentials ...Credential) Option[FengChao] {
+	return func(option *FengChao) {
+		option.credentialList = append(option.credentialList, credentials...)
+	}
+}
+
+// WithTokenSource 使用自定义的来源获取token, 例如sidecar签发的token或者密钥管理服务, 不需要持有SecretKey
+// NewFengChao的apiKey为空时只使用这个来源, 多次调用时按照凭证池的策略选择
+func WithTokenSource(source TokenSource) Option[FengChao] {
+	return WithCredentials(Credential{Source: source})
+}
+
+// WithCredentialPool 设置凭证池的选择策略和暂停使用的时间
+func WithCredentialPool(config CredentialPoolConfig) Option[FengChao] {
+	return func(option *FengChao) {
+		option.credentialConfig = config
+	}
+}
+
+// WithTokenRefreshWindow 设置token过期前多久开始在后台刷新, 刷新期间继续使用当前的token
+func WithTokenRefreshWindow(window time.Duration) Option[FengChao] {
+	return func(option *FengChao) {
+		option.tokenRefreshWindow = window
+	}
+}
+
+// WithControlPlaneTimeout 设置获取token、加载模型等控制面请求的超时时间, 调用方的ctx取消或者超时时会立即返回
+func WithControlPlaneTimeout(timeout time.Duration) Option[FengChao] {
+	return func(option *FengChao) {
+		option.controlPlaneTimeout = timeout
+	}
+}
+
+// WithEndpoints 添加备用的服务地址, NewFengChao的baseUrl作为优先级为0的服务地址
+// 请求遇到连接错误或者5xx时会按照优先级切换到下一个服务地址
+func WithEndpoints(endpoints ...Endpoint) Option[FengChao] {
+	return func(option *FengChao) {
+		option.endpointList = append(option.endpointList, endpoints...)
+	}
+}
+
+// WithHealthCheck 设置不可用服务地址的健康检查, 只在配置了多个服务地址时生效
+func WithHealthCheck(check HealthCheck) Option[FengChao] {
+	return func(option *FengChao) {
+		option.healthCheck = check
+	}
+}
@@ -0,0 +1,143 @@
+package fengchaogo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTransport 统计请求次数的传输层
+type countingTransport struct {
+	count atomic.Int32
+	next  http.RoundTripper
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.count.Add(1)
+	return c.next.RoundTrip(req)
+}
+
+func TestNewFengChao_Options(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != "my-service "+DefaultUserAgent {
+			t.Errorf("User-Agent = %q, want %q", got, "my-service "+DefaultUserAgent)
+		}
+		if got := r.Header.Get("X-Tenant"); got != "ijiwei" {
+			t.Errorf("X-Tenant = %q, want %q", got, "ijiwei")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			json.NewEncoder(w).Encode(map[string]any{"status": 200, "token": "token"})
+		case "/chat/":
+			json.NewEncoder(w).Encode(map[string]any{
+				"status":  200,
+				"choices": []map[string]any{{"message": map[string]any{"role": RoleAssistant, "content": "hi"}}},
+			})
+		}
+	}))
+	defer server.Close()
+
+	transport := &countingTransport{next: http.DefaultTransport}
+	client := NewFengChao("key", "secret", server.URL,
+		WithTransport(transport),
+		WithHeaders(map[string]string{"X-Tenant": "ijiwei"}),
+		WithUserAgent("my-service "+DefaultUserAgent),
+	)
+
+	res, err := client.ChatCompletion(context.Background(), NewUserMessage("hello"))
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	if res.String() != "hi" {
+		t.Errorf("ChatCompletion() = %v, want %v", res.String(), "hi")
+	}
+	if got := transport.count.Load(); got != 2 {
+		t.Errorf("transport requests = %d, want %d", got, 2)
+	}
+}
+
+func TestNewFengChao_TransportOptions(t *testing.T) {
+	const proxy = "http://proxy.example.com:8080"
+	target, _ := http.NewRequest(http.MethodGet, "http://fengchao.api/chat/", nil)
+	proxyOf := func(t *testing.T, transport *http.Transport) string {
+		t.Helper()
+		if transport.Proxy == nil {
+			return ""
+		}
+		u, err := transport.Proxy(target)
+		if err != nil || u == nil {
+			return ""
+		}
+		return u.String()
+	}
+
+	t.Run("default transport", func(t *testing.T) {
+		client := NewFengChao("key", "secret", "http://fengchao.api",
+			WithProxy(proxy),
+			WithConnectionPool(20, 10, time.Minute),
+		)
+		transport, ok := client.client.GetClient().Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("transport = %T, want *http.Transport", client.client.GetClient().Transport)
+		}
+		if got := proxyOf(t, transport); got != proxy {
+			t.Errorf("proxy = %q, want %q", got, proxy)
+		}
+		if transport.MaxIdleConns != 20 || transport.MaxIdleConnsPerHost != 10 || transport.IdleConnTimeout != time.Minute {
+			t.Errorf("pool = %d/%d/%v, want 20/10/1m0s", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+		}
+	})
+
+	t.Run("http client", func(t *testing.T) {
+		original := &http.Transport{MaxIdleConns: 1}
+		httpClient := &http.Client{Timeout: 7 * time.Second, Transport: original}
+		client := NewFengChao("key", "secret", "http://fengchao.api",
+			WithHTTPClient(httpClient),
+			WithProxy(proxy),
+			WithConnectionPool(20, 10, time.Minute),
+		)
+		if got := client.client.GetClient().Timeout; got != 7*time.Second {
+			t.Errorf("timeout = %v, want 7s", got)
+		}
+		transport, ok := client.client.GetClient().Transport.(*http.Transport)
+		if !ok || transport == original {
+			t.Fatalf("transport = %T %p, want a copy of %p", client.client.GetClient().Transport, transport, original)
+		}
+		if got := proxyOf(t, transport); got != proxy {
+			t.Errorf("proxy = %q, want %q", got, proxy)
+		}
+		if transport.MaxIdleConns != 20 || transport.MaxIdleConnsPerHost != 10 || transport.IdleConnTimeout != time.Minute {
+			t.Errorf("pool = %d/%d/%v, want 20/10/1m0s", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+		}
+		// 调用方的http客户端和Transport保持不变
+		if httpClient.Transport != original || original.Proxy != nil || original.MaxIdleConns != 1 {
+			t.Errorf("caller http client was modified: %+v", httpClient)
+		}
+	})
+
+	t.Run("custom transport", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		logger := NewSlogLogger(slog.New(slog.NewJSONHandler(buffer, nil)))
+		transport := &countingTransport{next: http.DefaultTransport}
+		client := NewFengChao("key", "secret", "http://fengchao.api",
+			WithLogger(logger),
+			WithTransport(transport),
+			WithProxy(proxy),
+		)
+		// 代理不能应用于自定义的传输层, 保持原样并记录警告
+		if got := client.client.GetClient().Transport; got != transport {
+			t.Errorf("transport = %T, want the custom transport", got)
+		}
+		if !strings.Contains(buffer.String(), "fengchao proxy and connection pool are ignored for custom transport") {
+			t.Errorf("log output missing warning: %s", buffer)
+		}
+	})
+}
@@ -2,8 +2,11 @@ package fengchaogo
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -29,10 +32,32 @@ type ChatCompletion struct {
 	IsSensitive bool `json:"is_sensitive"`
 	// MaxTokens 最大长度
 	MaxTokens int `json:"max_tokens,omitempty"`
-	// Stop 停用词
+	// Stop 停止序列, 生成的内容遇到任意一个时停止
+	Stop []string `json:"stop,omitempty"`
+	// N 生成的结果数量, 通过ChatCompletionResult.Choices返回
+	N int `json:"n,omitempty"`
+	// PresencePenalty 存在惩罚, 取值范围[-2, 2]
+	PresencePenalty float64 `json:"presence_penalty,omitempty"`
+	// FrequencyPenalty 频率惩罚, 取值范围[-2, 2]
+	FrequencyPenalty float64 `json:"frequency_penalty,omitempty"`
+	// Seed 随机种子, 相同的种子和参数尽量返回相同的结果
+	Seed *int64 `json:"seed,omitempty"`
+	// Logprobs 是否返回输出token的对数概率
+	Logprobs bool `json:"logprobs,omitempty"`
+	// TopLogprobs 每个位置返回概率最高的token数量, 需要开启Logprobs
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+	// ResponseFormat 输出格式
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// Tools 模型可以调用的工具
+	Tools []Tool `json:"tools,omitempty"`
+	// ToolChoice 工具的选择方式
+	ToolChoice string `json:"tool_choice,omitempty"`
+	// History 历史消息
 	History []*Message `json:"history,omitempty"`
 	// Query 问题
 	Query string `json:"query"`
+	// QueryParts 问题中文本以外的内容片段, 例如图片, 不为空时query序列化为内容片段数组
+	QueryParts []ContentPart `json:"-"`
 	// System 系统消息
 	System string `json:"system"`
 	// Mode 是否流式返回
@@ -43,10 +68,20 @@ type ChatCompletion struct {
 	// Variables 变量
 	variables map[string]interface{}
 
-	// Stop 停用词
-	Stop []string `json:"-"`
-	// Timeout 超时时间
+	// Timeout 超时时间, 开启重试时为单次请求的超时时间
 	Timeout int `json:"-"`
+	// retry 重试策略, 为空时使用客户端的配置
+	retry *RetryPolicy
+	// outputRepairs 结构化输出不符合要求时重新生成的次数, 为空时使用DefaultOutputRepairs
+	outputRepairs *int
+	// maxToolIterations 最多调用工具的轮数, 为空时使用DefaultMaxToolIterations
+	maxToolIterations *int
+	// fallbackModels 备用模型
+	fallbackModels []string
+	// fallbackOn 切换到备用模型的错误分类, 为空时使用DefaultFallbackErrors
+	fallbackOn []error
+	// contextCheck 发送前的上下文长度检查
+	contextCheck ContextCheck
 }
 
 // DefaultChatCompletionOption 默认配置, 可以覆盖
@@ -105,10 +140,11 @@ func (cc *ChatCompletion) LoadPromptTemplates(prompt Prompt) ([]*Message, error)
 	if messages[len(messages)-1].Role != RoleUser {
 		return nil, errors.New("last message must be user role message")
 	}
-	query := messages[len(messages)-1].Content
+	query := messages[len(messages)-1]
 	messages = messages[:len(messages)-1]
 
-	cc.Query = query
+	cc.Query = query.Content
+	cc.QueryParts = query.Parts
 	cc.History = messages
 	return originalMessages, nil
 }
@@ -120,18 +156,18 @@ func NewChatCompletion(helpers ...Option[ChatCompletion]) *ChatCompletion {
 	return ChatCompletionOption
 }
 
+// newChatCompletion 使用客户端的默认配置和本次请求的配置创建ChatCompletion参数
+func (f *FengChao) newChatCompletion(helpers ...Option[ChatCompletion]) *ChatCompletion {
+	return NewChatCompletion(append(slices.Clone(f.chatOptions), helpers...)...)
+}
+
 // ChatCompletionResult 聊天结果
 type ChatCompletionResult struct {
-	RequestID string `json:"request_id"`
-	Object    string `json:"object"`
-	Created   string `json:"created"`
-	Choices   []struct {
-		Index        int     `json:"index"`
-		Role         string  `json:"role"`
-		FinishReason string  `json:"finish_reason"`
-		Message      Message `json:"message"`
-	} `json:"choices"`
-	Usage struct {
+	RequestID string                 `json:"request_id"`
+	Object    string                 `json:"object"`
+	Created   string                 `json:"created"`
+	Choices   []ChatCompletionChoice `json:"choices"`
+	Usage     struct {
 		PromptTokens     int `json:"prompt_tokens"`
 		CompletionTokens int `json:"completion_tokens"`
 		TotalTokens      int `json:"total_tokens"`
@@ -139,6 +175,20 @@ type ChatCompletionResult struct {
 	Msg     string `json:"msg"`
 	Status  int    `json:"status"`
 	History []*Message
+	// Model 实际回答的模型
+	Model string `json:"model,omitempty"`
+	// Attempts 设置备用模型时每个模型的请求记录
+	Attempts []ModelAttempt `json:"-"`
+}
+
+// ChatCompletionChoice 生成的结果, 设置N时有多个
+type ChatCompletionChoice struct {
+	Index        int     `json:"index"`
+	Role         string  `json:"role"`
+	FinishReason string  `json:"finish_reason"`
+	Message      Message `json:"message"`
+	// Logprobs 输出token的对数概率, 开启Logprobs时返回
+	Logprobs *Logprobs `json:"logprobs,omitempty"`
 }
 
 // ChatCompletionError 聊天错误
@@ -154,7 +204,7 @@ func (cce *ChatCompletionError) String() string {
 // VerifyError 验证错误,实现了StreamAble
 func chatCompletionErrorHandler(ccr ChatCompletionResult) error {
 	if ccr.Status != 200 {
-		return fmt.Errorf("chat completion failed: [%d]%s", ccr.Status, ccr.Msg)
+		return newAPIError(0, ccr.Status, ccr.Msg, ccr.RequestID, nil)
 	}
 
 	return nil
@@ -175,6 +225,17 @@ func (r *ChatCompletionResult) String() string {
 	return r.Choices[0].Message.Content
 }
 
+// Contents 获取所有结果的正文内容, 按照Index排序
+func (r *ChatCompletionResult) Contents() []string {
+	choices := slices.Clone(r.Choices)
+	slices.SortStableFunc(choices, func(a, b ChatCompletionChoice) int { return a.Index - b.Index })
+	contents := make([]string, 0, len(choices))
+	for _, choice := range choices {
+		contents = append(contents, choice.Message.Content)
+	}
+	return contents
+}
+
 // GetHistoryPrompts 获取历史消息（Prompt）
 func (r *ChatCompletionResult) GetHistoryPrompts() *PromptTemplate {
 	if r.History == nil {
@@ -192,45 +253,15 @@ func (r *ChatCompletionResult) GetHistoryPrompts() *PromptTemplate {
 
 // ChatCompletion 聊天
 func (f *FengChao) ChatCompletion(ctx context.Context, prompt Prompt, chatCompletionOption ...Option[ChatCompletion]) (*ChatCompletionResult, error) {
-	ChatCompletionParams := NewChatCompletion(chatCompletionOption...)
+	ChatCompletionParams := f.newChatCompletion(chatCompletionOption...)
 
 	originalMessages, err := ChatCompletionParams.LoadPromptTemplates(prompt)
 	if err != nil {
-		return nil, fmt.Errorf("fail to load prompt template cause: %s", err)
+		return nil, fmt.Errorf("fail to load prompt template cause: %w", err)
 	}
 
-	token, err := f.getAuthToken()
+	complettionResult, err := f.chat(ctx, RequestInvoke, ChatCompletionParams)
 	if err != nil {
-		return nil, fmt.Errorf("auth failed, %s", err)
-	}
-
-	// 设置超时
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(ChatCompletionParams.Timeout)*time.Second)
-	defer cancel()
-	resp, err := f.client.R().
-		SetContext(ctx).
-		SetBody(ChatCompletionParams).
-		SetHeaderMultiValues(map[string][]string{
-			"Content-Type":  {"application/json"},
-			"Authorization": {token},
-		}).
-		SetError(&ChatCompletionError{}).
-		SetResult(&ChatCompletionResult{}).
-		Post("/chat/")
-
-	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return nil, fmt.Errorf("request timeout")
-		}
-		return nil, err
-	}
-	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("chat completion error: %s", resp.Error().(*ChatCompletionError).String())
-	}
-
-	complettionResult := resp.Result().(*ChatCompletionResult)
-
-	if err := complettionResult.HandleError(); err != nil {
 		return complettionResult, err
 	}
 
@@ -244,46 +275,153 @@ func (f *FengChao) ChatCompletion(ctx context.Context, prompt Prompt, chatComple
 
 // QuickCompletion 使用预定义prompt, 快速生成文本
 func (f *FengChao) QuickCompletion(ctx context.Context, chatCompletionOption ...Option[ChatCompletion]) (*ChatCompletionResult, error) {
-	ChatCompletionParams := NewChatCompletion(chatCompletionOption...)
+	ChatCompletionParams := f.newChatCompletion(chatCompletionOption...)
 
 	if ChatCompletionParams.PredefinedPrompts == "" || ChatCompletionParams.Query == "" {
 		return nil, fmt.Errorf("prompt or query is empty")
 	}
 
-	token, err := f.getAuthToken()
+	return f.chat(ctx, RequestQuick, ChatCompletionParams)
+}
+
+// chat 发送非流式的对话请求, 失败时按照重试策略进行重试, 设置备用模型时依次切换模型
+func (f *FengChao) chat(ctx context.Context, kind RequestKind, params *ChatCompletion) (*ChatCompletionResult, error) {
+	var model string
+	result, attempts, err := withFallback(ctx, f, params, func(params *ChatCompletion) (*ChatCompletionResult, error) {
+		model = params.Model
+		return f.chatModel(ctx, kind, params)
+	})
+	if result != nil {
+		if result.Model == "" {
+			result.Model = model
+		}
+		result.Attempts = attempts
+	}
+	return result, err
+}
+
+// chatModel 使用一个模型发送非流式的对话请求, 失败时按照重试策略进行重试
+func (f *FengChao) chatModel(ctx context.Context, kind RequestKind, params *ChatCompletion) (*ChatCompletionResult, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	if err := f.checkVision(ctx, params); err != nil {
+		return nil, err
+	}
+	if err := f.checkContextWindow(ctx, params); err != nil {
+		return nil, err
+	}
+	ctx, span := f.startSpan(ctx, kind, params)
+	metrics := f.startMetrics(kind, params.Model)
+	retrier := f.newRetrier(ctx, params)
+	for {
+		result, err := f.chatOnce(ctx, kind, params)
+		if err != nil && retrier.next(err) {
+			continue
+		}
+		span.SetAttributes(attrRetryCount.Int(retrier.retries()))
+		endSpan(span, result, err)
+		metrics.finish(result, err)
+		return result, err
+	}
+}
+
+// chatOnce 发送一次非流式的对话请求
+func (f *FengChao) chatOnce(ctx context.Context, kind RequestKind, params *ChatCompletion) (result *ChatCompletionResult, err error) {
+	estimated, err := f.waitRateLimit(ctx, params)
 	if err != nil {
-		return nil, fmt.Errorf("fail to auth cause: %s", err)
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(ChatCompletionParams.Timeout)*time.Second)
-	defer cancel()
-	resp, err := f.client.R().
-		SetContext(ctx).
-		SetBody(ChatCompletionParams).
-		SetHeaderMultiValues(map[string][]string{
-			"Content-Type":  {"application/json"},
-			"Authorization": {token},
-		}).
-		SetError(&ChatCompletionError{}).
-		SetResult(&ChatCompletionResult{}).
-		Post("/chat/")
+	done, err := f.allowCircuit(params)
+	if err != nil {
+		// 熔断时没有发送请求, 归还预估的token数
+		f.refundRateLimit(params, estimated)
+		return nil, err
+	}
+	defer func() { done(err) }()
 
+	cred := f.credentials.acquire()
+	defer func() { f.credentials.release(cred, result, err) }()
+
+	// 设置超时
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(params.Timeout)*time.Second)
+	defer cancel()
+	resp, err := f.doFailover(ctx, cred, func(token string) *Request {
+		return newChatRequest(kind, params, token)
+	})
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
-			return nil, fmt.Errorf("request timeout")
+			return nil, fmt.Errorf("%w: %w", ErrTimeout, err)
 		}
 		return nil, err
 	}
 
-	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("chat completion error: %s", resp.Error().(*ChatCompletionError).String())
+	if resp.StatusCode != 200 {
+		return nil, handleErrorResponse(resp, params.RequestID)
 	}
 
-	complettionResult := resp.Result().(*ChatCompletionResult)
+	complettionResult := resp.Result
+	f.adjustRateLimit(params, estimated, complettionResult.Usage.TotalTokens)
 
 	if err := complettionResult.HandleError(); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			apiErr.HTTPStatus = resp.StatusCode
+			apiErr.Body = resp.Raw
+			if apiErr.RequestID == "" {
+				apiErr.RequestID = params.RequestID
+			}
+		}
 		return complettionResult, err
 	}
 
 	return complettionResult, nil
 }
+
+// newChatRequest 创建对话请求
+func newChatRequest(kind RequestKind, params *ChatCompletion, token string) *Request {
+	return &Request{
+		Kind:   kind,
+		Method: http.MethodPost,
+		Path:   "/chat/",
+		Header: http.Header{
+			"Content-Type":  {"application/json"},
+			"Authorization": {token},
+		},
+		Params: params,
+	}
+}
+
+// logRequest 记录对话请求
+func (f *FengChao) logRequest(req *Request) {
+	params := req.Params
+	args := []any{"request_id", params.RequestID, "model", params.Model, "kind", req.Kind}
+	if f.debug {
+		body, _ := json.Marshal(params)
+		args = append(args, "headers", redactHeaders(req.Header), "body", string(body))
+	}
+	f.logger.Debug("fengchao chat completion request", args...)
+}
+
+// logResponse 记录对话响应
+func (f *FengChao) logResponse(req *Request, resp *Response, latency time.Duration) {
+	params := req.Params
+	args := []any{"request_id", params.RequestID, "model", params.Model, "http_status", resp.StatusCode, "latency", latency}
+	if result := resp.Result; result != nil {
+		args = append(args,
+			"status", result.Status,
+			"prompt_tokens", result.Usage.PromptTokens,
+			"completion_tokens", result.Usage.CompletionTokens,
+			"total_tokens", result.Usage.TotalTokens,
+		)
+	}
+	if f.debug && resp.Stream == nil {
+		args = append(args, "body", string(resp.Raw))
+	}
+	if resp.StatusCode != 200 {
+		f.logger.Error("fengchao chat completion failed", args...)
+		return
+	}
+	f.logger.Info("fengchao chat completion response", args...)
+}
@@ -2,55 +2,175 @@ package fengchaogo
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"iter"
-	"net/http"
+	"sync"
 )
 
 // ChatCompletionStream 流式聊天
 func (f *FengChao) ChatCompletionStream(ctx context.Context, prompt Prompt, chatCompletionOption ...Option[ChatCompletion]) (*JsonStreamReader[ChatCompletionResult], error) {
-	ChatCompletionParams := NewChatCompletion(chatCompletionOption...)
+	ChatCompletionParams := f.newChatCompletion(chatCompletionOption...)
 	ChatCompletionParams.Mode = StreamMode
 
 	_, err := ChatCompletionParams.LoadPromptTemplates(prompt)
 	if err != nil {
-		return nil, fmt.Errorf("fail to load prompt template cause: %s", err)
+		return nil, fmt.Errorf("fail to load prompt template cause: %w", err)
 	}
 
-	token, err := f.getAuthToken()
+	// 设置备用模型时, 建立数据流失败会切换模型, 已经返回数据后不再切换
+	reader, _, err := withFallback(ctx, f, ChatCompletionParams, func(params *ChatCompletion) (*JsonStreamReader[ChatCompletionResult], error) {
+		return f.chatStream(ctx, params)
+	})
+	return reader, err
+}
+
+// chatStream 使用一个模型建立流式对话, 建立失败时按照重试策略进行重试
+func (f *FengChao) chatStream(ctx context.Context, ChatCompletionParams *ChatCompletion) (*JsonStreamReader[ChatCompletionResult], error) {
+	if err := ChatCompletionParams.Validate(); err != nil {
+		return nil, err
+	}
+	if err := f.checkVision(ctx, ChatCompletionParams); err != nil {
+		return nil, err
+	}
+	if err := f.checkContextWindow(ctx, ChatCompletionParams); err != nil {
+		return nil, err
+	}
+
+	ctx, span := f.startSpan(ctx, RequestStream, ChatCompletionParams)
+	metrics := f.startMetrics(RequestStream, ChatCompletionParams.Model)
+	retrier := f.newRetrier(ctx, ChatCompletionParams)
+	attempt, err := f.openStream(ctx, ChatCompletionParams, retrier)
 	if err != nil {
-		return nil, fmt.Errorf("fail to auth cause: %s", err)
+		span.SetAttributes(attrRetryCount.Int(retrier.retries()))
+		endSpan(span, nil, err)
+		metrics.finish(nil, err)
+		return nil, err
+	}
+
+	var usage *ChatCompletionResult
+	reader := &JsonStreamReader[ChatCompletionResult]{
+		reader:       bufio.NewReader(attempt.stream),
+		body:         attempt.stream,
+		errorHandler: chatCompletionErrorHandler,
+		// 还没有返回数据包时, 可以重新建立数据流
+		reopen: func(err error) (io.ReadCloser, error) {
+			attempt.record(err)
+			f.refundRateLimit(ChatCompletionParams, attempt.estimated)
+			f.credentials.release(attempt.credential, nil, err)
+			if !retrier.next(err) {
+				attempt = nil
+				return nil, err
+			}
+			if attempt, err = f.openStream(ctx, ChatCompletionParams, retrier); err != nil {
+				return nil, err
+			}
+			return attempt.stream, nil
+		},
+	}
+	reader.OnMessage(func(msg *ChatCompletionResult) {
+		// 返回数据包后熔断器记录为成功
+		attempt.record(nil)
+		if msg.Usage.TotalTokens > 0 {
+			usage = msg
+		}
+	})
+	reader.OnClose(func(err error) {
+		span.SetAttributes(attrRetryCount.Int(retrier.retries()))
+		if attempt == nil {
+			return
+		}
+		totalTokens := 0
+		if usage != nil {
+			totalTokens = usage.Usage.TotalTokens
+		}
+		f.adjustRateLimit(ChatCompletionParams, attempt.estimated, totalTokens)
+		f.credentials.release(attempt.credential, usage, err)
+		attempt.record(err)
+	})
+	traceStream(span, reader)
+	metrics.observeStream(reader)
+
+	return reader, nil
+}
+
+// streamAttempt 建立成功的数据流, 关闭时需要归还凭证并校准限流器
+type streamAttempt struct {
+	// stream 数据流
+	stream io.ReadCloser
+	// estimated 限流器预估的token数
+	estimated int
+	// credential 使用的凭证
+	credential *credential
+	// circuit 记录熔断器的请求结果
+	circuit func(err error)
+	// recorded 是否已经记录熔断器的请求结果
+	recorded sync.Once
+}
+
+// record 记录熔断器的请求结果, 只记录第一次: 返回数据包时为成功, 返回数据包之前失败时为失败
+func (a *streamAttempt) record(err error) {
+	a.recorded.Do(func() { a.circuit(err) })
+}
+
+// openStream 建立数据流, 失败时按照重试策略进行重试
+func (f *FengChao) openStream(ctx context.Context, params *ChatCompletion, retrier *retrier) (*streamAttempt, error) {
+	for {
+		attempt, err := f.openStreamOnce(ctx, params)
+		if err != nil && retrier.next(err) {
+			continue
+		}
+		return attempt, err
 	}
+}
 
-	resp, err := f.client.R().
-		SetContext(ctx).
-		SetBody(ChatCompletionParams).
-		SetHeaderMultiValues(map[string][]string{
-			"Content-Type":  {"application/json"},
-			"Authorization": {token},
-		}).
-		SetDoNotParseResponse(true).
-		Post("/chat/")
+// openStreamOnce 建立一次数据流
+func (f *FengChao) openStreamOnce(ctx context.Context, params *ChatCompletion) (_ *streamAttempt, err error) {
+	estimated, err := f.waitRateLimit(ctx, params)
+	if err != nil {
+		return nil, err
+	}
 
+	done, err := f.allowCircuit(params)
 	if err != nil {
-		return nil, fmt.Errorf("fail to post request cause: %s", err)
+		// 熔断时没有发送请求, 归还预估的token数
+		f.refundRateLimit(params, estimated)
+		return nil, err
 	}
+	// 建立成功时在返回数据包或者失败时记录结果
+	defer func() {
+		if err != nil {
+			done(err)
+		}
+	}()
+
+	// 建立成功时凭证在数据流关闭后归还
+	cred := f.credentials.acquire()
+	defer func() {
+		if err != nil {
+			f.credentials.release(cred, nil, err)
+		}
+	}()
 
-	if resp.StatusCode() != 200 {
-		return nil, handleErrorResponse(resp.RawResponse)
+	resp, err := f.doFailover(ctx, cred, func(token string) *Request {
+		return newChatRequest(RequestStream, params, token)
+	})
+	if err != nil {
+		if errors.Is(err, ErrAuth) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("fail to post request cause: %w", err)
 	}
 
-	reader := &JsonStreamReader[ChatCompletionResult]{
-		reader:       bufio.NewReader(resp.RawResponse.Body),
-		resp:         resp.RawResponse,
-		errorHandler: chatCompletionErrorHandler,
+	if resp.StatusCode != 200 {
+		return nil, handleErrorResponse(resp, params.RequestID)
 	}
 
-	return reader, err
+	return &streamAttempt{stream: resp.Stream, estimated: estimated, credential: cred, circuit: done}, nil
 }
 
 // ChatCompletionStreamSimple 流式聊天
@@ -59,19 +179,15 @@ func (f *FengChao) ChatCompletionStreamSimple(ctx context.Context, prompt Prompt
 	return reader.Stream(), err
 }
 
-// handleErrorResponse 处理错误
-func handleErrorResponse(resp *http.Response) error {
-	buffer := bufio.NewReader(resp.Body)
-	data, err := buffer.ReadString('\n')
-	if err != nil {
-		if !errors.Is(err, io.EOF) {
-			return fmt.Errorf("response unknown error: %s", data)
-		}
-	}
+// handleErrorResponse 将失败的响应转换为APIError
+func handleErrorResponse(resp *Response, requestID string) *APIError {
+	msg := string(bytes.TrimSpace(resp.Raw))
+	data, _, _ := bytes.Cut(resp.Raw, []byte("\n"))
 	var chatCompletionError ChatCompletionError
-	err = json.Unmarshal([]byte(data), &chatCompletionError)
-	if err != nil {
-		return fmt.Errorf("response error: %s", data)
+	if err := json.Unmarshal(data, &chatCompletionError); err == nil && chatCompletionError.Detail != "" {
+		msg = chatCompletionError.String()
 	}
-	return fmt.Errorf("chat completion error: %s", chatCompletionError.String())
+	apiErr := newAPIError(resp.StatusCode, 0, msg, requestID, resp.Raw)
+	apiErr.RetryAfter = parseRetryAfter(resp.Header)
+	return apiErr
 }
@@ -0,0 +1,485 @@
+package fengchaogo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultProfile 默认的配置名称
+const DefaultProfile = "default"
+
+// 环境变量
+const (
+	// EnvApiKey fengchao api key
+	EnvApiKey = "FENGCHAO_KEY"
+	// EnvSecretKey fengchao secret key
+	EnvSecretKey = "FENGCHAO_SECRET"
+	// EnvBaseUrl 服务地址
+	EnvBaseUrl = "FENGCHAO_BASE_URL"
+	// EnvEndpoints 备用的服务地址, 多个地址使用逗号分隔, 按照顺序作为优先级
+	EnvEndpoints = "FENGCHAO_ENDPOINTS"
+	// EnvModel 默认模型
+	EnvModel = "FENGCHAO_MODEL"
+	// EnvTemperature 默认的temperature
+	EnvTemperature = "FENGCHAO_TEMPERATURE"
+	// EnvMaxTokens 默认的最大长度
+	EnvMaxTokens = "FENGCHAO_MAX_TOKENS"
+	// EnvTimeout 默认的对话超时时间, 单位为秒
+	EnvTimeout = "FENGCHAO_TIMEOUT"
+	// EnvDebug 是否输出请求和响应的详细内容
+	EnvDebug = "FENGCHAO_DEBUG"
+	// EnvConfig 配置文件路径, 设置后先加载配置文件, 再使用其他环境变量覆盖
+	EnvConfig = "FENGCHAO_CONFIG"
+	// EnvProfile 配置文件中使用的配置名称
+	EnvProfile = "FENGCHAO_PROFILE"
+)
+
+// Duration 配置文件中的时间, 使用time.ParseDuration的格式, 例如"500ms"、"10s"
+type Duration time.Duration
+
+// UnmarshalText 解析时间
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalText 格式化时间
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// RetryConfig 重试配置, 为零值的字段使用DefaultRetryPolicy
+type RetryConfig struct {
+	MaxAttempts    int      `json:"max_attempts" yaml:"max_attempts" toml:"max_attempts"`
+	InitialBackoff Duration `json:"initial_backoff" yaml:"initial_backoff" toml:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff" yaml:"max_backoff" toml:"max_backoff"`
+	Multiplier     float64  `json:"multiplier" yaml:"multiplier" toml:"multiplier"`
+	Jitter         float64  `json:"jitter" yaml:"jitter" toml:"jitter"`
+}
+
+// RateLimitConfig 限流配置
+type RateLimitConfig struct {
+	// Default 没有单独配置的模型使用的限流配置
+	Default RateLimit `json:"default" yaml:"default" toml:"default"`
+	// Models 按照模型单独配置
+	Models map[string]RateLimit `json:"models" yaml:"models" toml:"models"`
+}
+
+// Config 客户端配置, 可以从配置文件或者环境变量加载
+type Config struct {
+	// ApiKey fengchao api key
+	ApiKey string `json:"api_key" yaml:"api_key" toml:"api_key"`
+	// SecretKey fengchao secret key
+	SecretKey string `json:"secret_key" yaml:"secret_key" toml:"secret_key"`
+	// Credentials 额外的鉴权凭证
+	Credentials []Credential `json:"credentials" yaml:"credentials" toml:"credentials"`
+	// TokenFile 从文件中读取token, 不需要api_key和secret_key
+	TokenFile string `json:"token_file" yaml:"token_file" toml:"token_file"`
+	// TokenCommand 执行外部命令获取token, 第一个元素为命令, 不需要api_key和secret_key
+	TokenCommand []string `json:"token_command" yaml:"token_command" toml:"token_command"`
+	// CredentialPolicy 凭证的选择策略, 可选round_robin、least_used、weighted
+	CredentialPolicy string `json:"credential_policy" yaml:"credential_policy" toml:"credential_policy"`
+
+	// BaseUrl 服务地址
+	BaseUrl string `json:"base_url" yaml:"base_url" toml:"base_url"`
+	// Endpoints 备用的服务地址
+	Endpoints []Endpoint `json:"endpoints" yaml:"endpoints" toml:"endpoints"`
+
+	// Model 默认模型
+	Model string `json:"model" yaml:"model" toml:"model"`
+	// FallbackModels 默认的备用模型
+	FallbackModels []string `json:"fallback_models" yaml:"fallback_models" toml:"fallback_models"`
+	// Temperature 默认的temperature, 为空时不设置, 可以设置为0
+	Temperature *float64 `json:"temperature" yaml:"temperature" toml:"temperature"`
+	// TopP 默认的top_p, 为空时不设置, 可以设置为0
+	TopP *float64 `json:"top_p" yaml:"top_p" toml:"top_p"`
+	// MaxTokens 默认的最大长度
+	MaxTokens int `json:"max_tokens" yaml:"max_tokens" toml:"max_tokens"`
+	// ContextCheck 发送前的上下文长度检查, 可选off、warn、reject
+	ContextCheck string `json:"context_check" yaml:"context_check" toml:"context_check"`
+	// Timeout 默认的对话超时时间, 单位为秒
+	Timeout int `json:"timeout" yaml:"timeout" toml:"timeout"`
+	// ControlPlaneTimeout 获取token、加载模型等控制面请求的超时时间
+	ControlPlaneTimeout Duration `json:"control_plane_timeout" yaml:"control_plane_timeout" toml:"control_plane_timeout"`
+
+	// UserAgent 请求的User-Agent
+	UserAgent string `json:"user_agent" yaml:"user_agent" toml:"user_agent"`
+	// Proxy 代理地址
+	Proxy string `json:"proxy" yaml:"proxy" toml:"proxy"`
+	// Headers 默认请求头
+	Headers map[string]string `json:"headers" yaml:"headers" toml:"headers"`
+
+	// Retry 重试配置, 为空时不重试
+	Retry *RetryConfig `json:"retry" yaml:"retry" toml:"retry"`
+	// RateLimit 限流配置, 为空时不限流
+	RateLimit *RateLimitConfig `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+
+	// Debug 是否输出请求和响应的详细内容
+	Debug bool `json:"debug" yaml:"debug" toml:"debug"`
+}
+
+// credentialPolicies 凭证选择策略的配置名称
+var credentialPolicies = map[string]CredentialPolicy{
+	"round_robin": CredentialRoundRobin,
+	"least_used":  CredentialLeastUsed,
+	"weighted":    CredentialWeighted,
+}
+
+// contextChecks 上下文长度检查的配置名称
+var contextChecks = map[string]ContextCheck{
+	"off":    ContextCheckOff,
+	"warn":   ContextCheckWarn,
+	"reject": ContextCheckReject,
+}
+
+// envPattern 配置文件中引用环境变量的${VAR}
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv 将${VAR}替换为环境变量, 其他的$原样保留, 避免密钥中的$被当作变量
+func expandEnv(data []byte) []byte {
+	return envPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		return []byte(os.Getenv(string(envPattern.FindSubmatch(match)[1])))
+	})
+}
+
+// LoadConfig 加载配置文件中的指定配置, 文件的第一层为配置名称, profile为空时使用default
+// 根据扩展名解析yaml、yml、json或者toml, 文件内容中的${VAR}会替换为环境变量
+func LoadConfig(path string, profile string) (*Config, error) {
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file error: %w", err)
+	}
+	data = expandEnv(data)
+
+	profiles := make(map[string]*Config)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&profiles)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&profiles)
+	case ".toml":
+		var meta toml.MetaData
+		meta, err = toml.Decode(string(data), &profiles)
+		if err == nil && len(meta.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown fields %v", meta.Undecoded())
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q, want .yaml, .yml, .json or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s error: %w", path, err)
+	}
+
+	config, ok := profiles[profile]
+	if !ok || config == nil {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("profile %q not found in config file %s, available profiles: %s", profile, path, strings.Join(names, ", "))
+	}
+	return config, nil
+}
+
+// LoadEnv 使用环境变量覆盖配置, 只覆盖设置了的环境变量
+func (c *Config) LoadEnv() error {
+	var errs []error
+	if v, ok := os.LookupEnv(EnvApiKey); ok {
+		c.ApiKey = v
+	}
+	if v, ok := os.LookupEnv(EnvSecretKey); ok {
+		c.SecretKey = v
+	}
+	if v, ok := os.LookupEnv(EnvBaseUrl); ok {
+		c.BaseUrl = v
+	}
+	if v, ok := os.LookupEnv(EnvEndpoints); ok {
+		c.Endpoints = nil
+		for i, u := range strings.Split(v, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				c.Endpoints = append(c.Endpoints, Endpoint{URL: u, Priority: i + 1})
+			}
+		}
+	}
+	if v, ok := os.LookupEnv(EnvModel); ok {
+		c.Model = v
+	}
+	if v, ok := os.LookupEnv(EnvTemperature); ok {
+		temperature, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid number %q", EnvTemperature, v))
+		}
+		c.Temperature = &temperature
+	}
+	if v, ok := os.LookupEnv(EnvMaxTokens); ok {
+		maxTokens, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid integer %q", EnvMaxTokens, v))
+		}
+		c.MaxTokens = maxTokens
+	}
+	if v, ok := os.LookupEnv(EnvTimeout); ok {
+		timeout, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid integer %q", EnvTimeout, v))
+		}
+		c.Timeout = timeout
+	}
+	if v, ok := os.LookupEnv(EnvDebug); ok {
+		debug, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid boolean %q", EnvDebug, v))
+		}
+		c.Debug = debug
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid fengchao environment: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Validate 校验配置, 返回所有不合法的字段
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.ApiKey == "" && len(c.Credentials) == 0 && c.TokenFile == "" && len(c.TokenCommand) == 0 {
+		invalid("api_key, credentials, token_file or token_command is required")
+	}
+	if c.ApiKey != "" && c.SecretKey == "" {
+		invalid("secret_key is required when api_key is set")
+	}
+	for i, cred := range c.Credentials {
+		if cred.ApiKey == "" || cred.SecretKey == "" {
+			invalid("credentials[%d]: api_key and secret_key are required", i)
+		}
+		if cred.Weight < 0 {
+			invalid("credentials[%d].weight must not be negative, got %d", i, cred.Weight)
+		}
+	}
+	if _, ok := credentialPolicies[c.CredentialPolicy]; c.CredentialPolicy != "" && !ok {
+		invalid("credential_policy must be one of round_robin, least_used, weighted, got %q", c.CredentialPolicy)
+	}
+
+	if c.BaseUrl == "" && len(c.Endpoints) == 0 {
+		invalid("base_url or endpoints is required")
+	}
+	if c.BaseUrl != "" && !isHTTPURL(c.BaseUrl) {
+		invalid("base_url must be an absolute http(s) url, got %q", c.BaseUrl)
+	}
+	for i, endpoint := range c.Endpoints {
+		if !isHTTPURL(endpoint.URL) {
+			invalid("endpoints[%d].url must be an absolute http(s) url, got %q", i, endpoint.URL)
+		}
+	}
+	if c.Proxy != "" && !isHTTPURL(c.Proxy) && !strings.HasPrefix(c.Proxy, "socks5://") {
+		invalid("proxy must be an http(s) or socks5 url, got %q", c.Proxy)
+	}
+
+	if c.Temperature != nil && (*c.Temperature < 0 || *c.Temperature > 2) {
+		invalid("temperature must be in [0, 2], got %v", *c.Temperature)
+	}
+	if c.TopP != nil && (*c.TopP < 0 || *c.TopP > 1) {
+		invalid("top_p must be in [0, 1], got %v", *c.TopP)
+	}
+	if c.MaxTokens < 0 {
+		invalid("max_tokens must not be negative, got %d", c.MaxTokens)
+	}
+	if _, ok := contextChecks[c.ContextCheck]; c.ContextCheck != "" && !ok {
+		invalid("context_check must be one of off, warn, reject, got %q", c.ContextCheck)
+	}
+	if c.Timeout < 0 {
+		invalid("timeout must not be negative, got %d", c.Timeout)
+	}
+	if c.ControlPlaneTimeout < 0 {
+		invalid("control_plane_timeout must not be negative, got %s", time.Duration(c.ControlPlaneTimeout))
+	}
+
+	if r := c.Retry; r != nil {
+		if r.MaxAttempts < 0 {
+			invalid("retry.max_attempts must not be negative, got %d", r.MaxAttempts)
+		}
+		if r.InitialBackoff < 0 || r.MaxBackoff < 0 {
+			invalid("retry.initial_backoff and retry.max_backoff must not be negative")
+		}
+		if r.Multiplier != 0 && r.Multiplier < 1 {
+			invalid("retry.multiplier must be at least 1, got %v", r.Multiplier)
+		}
+		if r.Jitter < 0 || r.Jitter > 1 {
+			invalid("retry.jitter must be in [0, 1], got %v", r.Jitter)
+		}
+	}
+	if r := c.RateLimit; r != nil {
+		if r.Default.RequestsPerMinute < 0 || r.Default.TokensPerMinute < 0 {
+			invalid("rate_limit.default must not be negative")
+		}
+		for model, limit := range r.Models {
+			if limit.RequestsPerMinute < 0 || limit.TokensPerMinute < 0 {
+				invalid("rate_limit.models[%s] must not be negative", model)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid fengchao config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// isHTTPURL 是否为http(s)的绝对地址
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Options 将配置转换为客户端配置, 不包括ApiKey、SecretKey和BaseUrl
+func (c *Config) Options() []Option[FengChao] {
+	var options []Option[FengChao]
+	if len(c.Credentials) > 0 {
+		options = append(options, WithCredentials(c.Credentials...))
+	}
+	if c.TokenFile != "" {
+		options = append(options, WithTokenSource(FileTokenSource(c.TokenFile, DefaultTokenFileInterval)))
+	}
+	if len(c.TokenCommand) > 0 {
+		options = append(options, WithTokenSource(CommandTokenSource(c.TokenCommand[0], c.TokenCommand[1:]...)))
+	}
+	if c.CredentialPolicy != "" {
+		config := DefaultCredentialPoolConfig
+		config.Policy = credentialPolicies[c.CredentialPolicy]
+		options = append(options, WithCredentialPool(config))
+	}
+	if len(c.Endpoints) > 0 {
+		options = append(options, WithEndpoints(c.Endpoints...))
+	}
+	if c.ControlPlaneTimeout != 0 {
+		options = append(options, WithControlPlaneTimeout(time.Duration(c.ControlPlaneTimeout)))
+	}
+	if c.UserAgent != "" {
+		options = append(options, WithUserAgent(c.UserAgent))
+	}
+	if c.Proxy != "" {
+		options = append(options, WithProxy(c.Proxy))
+	}
+	if len(c.Headers) > 0 {
+		options = append(options, WithHeaders(c.Headers))
+	}
+	if c.Retry != nil {
+		options = append(options, WithRetryPolicy(c.Retry.policy()))
+	}
+	if c.RateLimit != nil {
+		options = append(options, WithRateLimiter(NewRateLimiter(c.RateLimit.Default, c.RateLimit.Models)))
+	}
+	if c.Debug {
+		options = append(options, WithDebug(true))
+	}
+
+	var chatOptions []Option[ChatCompletion]
+	if c.Model != "" {
+		chatOptions = append(chatOptions, WithModel(c.Model))
+	}
+	if len(c.FallbackModels) > 0 {
+		chatOptions = append(chatOptions, WithFallbackModels(c.FallbackModels...))
+	}
+	if c.Temperature != nil {
+		chatOptions = append(chatOptions, WithTemperature(*c.Temperature))
+	}
+	if c.TopP != nil {
+		chatOptions = append(chatOptions, WithTopP(*c.TopP))
+	}
+	if c.MaxTokens != 0 {
+		chatOptions = append(chatOptions, WithMaxTokens(c.MaxTokens))
+	}
+	if c.Timeout != 0 {
+		chatOptions = append(chatOptions, WithTimeout(c.Timeout))
+	}
+	if c.ContextCheck != "" {
+		chatOptions = append(chatOptions, WithContextCheck(contextChecks[c.ContextCheck]))
+	}
+	if len(chatOptions) > 0 {
+		options = append(options, WithChatCompletionOptions(chatOptions...))
+	}
+	return options
+}
+
+// policy 转换为重试策略
+func (r *RetryConfig) policy() *RetryPolicy {
+	policy := *DefaultRetryPolicy
+	if r.MaxAttempts != 0 {
+		policy.MaxAttempts = r.MaxAttempts
+	}
+	if r.InitialBackoff != 0 {
+		policy.InitialBackoff = time.Duration(r.InitialBackoff)
+	}
+	if r.MaxBackoff != 0 {
+		policy.MaxBackoff = time.Duration(r.MaxBackoff)
+	}
+	if r.Multiplier != 0 {
+		policy.Multiplier = r.Multiplier
+	}
+	if r.Jitter != 0 {
+		policy.Jitter = r.Jitter
+	}
+	return &policy
+}
+
+// NewFengChaoFromConfig 使用配置创建客户端, options会覆盖配置中的值
+func NewFengChaoFromConfig(config *Config, options ...Option[FengChao]) (*FengChao, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	options = append(config.Options(), options...)
+	return NewFengChao(config.ApiKey, config.SecretKey, config.BaseUrl, options...), nil
+}
+
+// NewFengChaoFromFile 使用配置文件中的指定配置创建客户端, options会覆盖配置中的值
+func NewFengChaoFromFile(path string, profile string, options ...Option[FengChao]) (*FengChao, error) {
+	config, err := LoadConfig(path, profile)
+	if err != nil {
+		return nil, err
+	}
+	return NewFengChaoFromConfig(config, options...)
+}
+
+// NewFengChaoFromEnv 使用环境变量创建客户端, 设置了FENGCHAO_CONFIG时先加载配置文件, 再使用其他环境变量覆盖
+// options会覆盖环境变量和配置文件中的值
+func NewFengChaoFromEnv(options ...Option[FengChao]) (*FengChao, error) {
+	config := &Config{}
+	if path := os.Getenv(EnvConfig); path != "" {
+		var err error
+		if config, err = LoadConfig(path, os.Getenv(EnvProfile)); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.LoadEnv(); err != nil {
+		return nil, err
+	}
+	return NewFengChaoFromConfig(config, options...)
+}
@@ -0,0 +1,299 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+const yamlConfig = `
+default:
+  api_key: key
+  secret_key: ${TEST_FENGCHAO_SECRET}
+  base_url: http://fengchao.api
+  endpoints:
+    - url: http://fengchao-backup.api
+      priority: 1
+  model: glm-4
+  temperature: 0.5
+  max_tokens: 1000
+  timeout: 30
+  retry:
+    max_attempts: 3
+    initial_backoff: 200ms
+  rate_limit:
+    default:
+      requests_per_minute: 60
+    models:
+      glm-4:
+        tokens_per_minute: 10000
+  debug: true
+staging:
+  api_key: staging-key
+  secret_key: staging-secret
+  base_url: http://fengchao-staging.api
+`
+
+const jsonConfig = `{
+  "default": {
+    "api_key": "key",
+    "secret_key": "${TEST_FENGCHAO_SECRET}",
+    "base_url": "http://fengchao.api",
+    "endpoints": [{"url": "http://fengchao-backup.api", "priority": 1}],
+    "model": "glm-4",
+    "temperature": 0.5,
+    "max_tokens": 1000,
+    "timeout": 30,
+    "retry": {"max_attempts": 3, "initial_backoff": "200ms"},
+    "rate_limit": {"default": {"requests_per_minute": 60}, "models": {"glm-4": {"tokens_per_minute": 10000}}},
+    "debug": true
+  },
+  "staging": {"api_key": "staging-key", "secret_key": "staging-secret", "base_url": "http://fengchao-staging.api"}
+}`
+
+const tomlConfig = `
+[default]
+api_key = "key"
+secret_key = "${TEST_FENGCHAO_SECRET}"
+base_url = "http://fengchao.api"
+model = "glm-4"
+temperature = 0.5
+max_tokens = 1000
+timeout = 30
+debug = true
+
+[[default.endpoints]]
+url = "http://fengchao-backup.api"
+priority = 1
+
+[default.retry]
+max_attempts = 3
+initial_backoff = "200ms"
+
+[default.rate_limit.default]
+requests_per_minute = 60
+
+[default.rate_limit.models.glm-4]
+tokens_per_minute = 10000
+
+[staging]
+api_key = "staging-key"
+secret_key = "staging-secret"
+base_url = "http://fengchao-staging.api"
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("TEST_FENGCHAO_SECRET", "secret")
+	temperature := 0.5
+	want := &fengchao.Config{
+		ApiKey:      "key",
+		SecretKey:   "secret",
+		BaseUrl:     "http://fengchao.api",
+		Endpoints:   []fengchao.Endpoint{{URL: "http://fengchao-backup.api", Priority: 1}},
+		Model:       "glm-4",
+		Temperature: &temperature,
+		MaxTokens:   1000,
+		Timeout:     30,
+		Retry:       &fengchao.RetryConfig{MaxAttempts: 3, InitialBackoff: fengchao.Duration(200 * time.Millisecond)},
+		RateLimit: &fengchao.RateLimitConfig{
+			Default: fengchao.RateLimit{RequestsPerMinute: 60},
+			Models:  map[string]fengchao.RateLimit{"glm-4": {TokensPerMinute: 10000}},
+		},
+		Debug: true,
+	}
+	for name, content := range map[string]string{"config.yaml": yamlConfig, "config.json": jsonConfig, "config.toml": tomlConfig} {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, name, content)
+			config, err := fengchao.LoadConfig(path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, want) {
+				t.Fatalf("got %+v, want %+v", config, want)
+			}
+			if err := config.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			staging, err := fengchao.LoadConfig(path, "staging")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if staging.ApiKey != "staging-key" || staging.BaseUrl != "http://fengchao-staging.api" {
+				t.Fatalf("unexpected staging profile: %+v", staging)
+			}
+
+			_, err = fengchao.LoadConfig(path, "production")
+			if err == nil || !strings.Contains(err.Error(), `profile "production" not found`) || !strings.Contains(err.Error(), "default, staging") {
+				t.Fatalf("got %v, want profile not found error with available profiles", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDollarSign(t *testing.T) {
+	t.Setenv("TEST_FENGCHAO_KEY", "env-key")
+	t.Setenv("cd12", "expanded")
+	// 只替换${VAR}, 其他的$原样保留
+	path := writeConfig(t, "config.yaml", "default:\n  api_key: ${TEST_FENGCHAO_KEY}\n  secret_key: ab$cd12$\n  base_url: http://fengchao.api\n")
+	config, err := fengchao.LoadConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ApiKey != "env-key" || config.SecretKey != "ab$cd12$" {
+		t.Fatalf("got api_key %q secret_key %q", config.ApiKey, config.SecretKey)
+	}
+}
+
+func TestConfigExplicitZero(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	defaults := fengchao.DefaultChatCompletionOption
+	fengchao.DefaultChatCompletionOption = defaults.Clone()
+	fengchao.DefaultChatCompletionOption.Temperature = 0.9
+	fengchao.DefaultChatCompletionOption.TopP = 0.8
+	t.Cleanup(func() { fengchao.DefaultChatCompletionOption = defaults })
+
+	// 配置文件中显式设置的0会覆盖默认配置, 没有设置时使用默认配置
+	for content, want := range map[string][2]float64{
+		"temperature: 0\n  top_p: 0\n": {0, 0},
+		"model: glm-4\n":               {0.9, 0.8},
+	} {
+		path := writeConfig(t, "config.yaml", "default:\n  api_key: key\n  secret_key: secret\n  base_url: "+server.URL+"\n  "+content)
+		config, err := fengchao.LoadConfig(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := fengchao.NewFengChaoFromConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.ChatCompletion(context.Background(), fengchao.NewUserMessage("你好")); err != nil {
+			t.Fatal(err)
+		}
+		if got := server.LastRequest(); got.Temperature != want[0] || got.TopP != want[1] {
+			t.Errorf("%q: got temperature %v top_p %v, want %v", content, got.Temperature, got.TopP, want)
+		}
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "default:\n  api_key: key\n  modle: glm-4\n")
+	if _, err := fengchao.LoadConfig(path, ""); err == nil || !strings.Contains(err.Error(), "modle") {
+		t.Fatalf("got %v, want unknown field error", err)
+	}
+	path = writeConfig(t, "config.ini", "")
+	if _, err := fengchao.LoadConfig(path, ""); err == nil || !strings.Contains(err.Error(), "unsupported config file format") {
+		t.Fatalf("got %v, want unsupported format error", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	temperature := 3.0
+	config := &fengchao.Config{
+		ApiKey:           "key",
+		BaseUrl:          "fengchao.api",
+		Temperature:      &temperature,
+		MaxTokens:        -1,
+		CredentialPolicy: "random",
+		ContextCheck:     "strict",
+		Retry:            &fengchao.RetryConfig{Jitter: 2},
+	}
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("want validation error")
+	}
+	for _, want := range []string{
+		"secret_key is required when api_key is set",
+		`base_url must be an absolute http(s) url, got "fengchao.api"`,
+		"temperature must be in [0, 2], got 3",
+		"max_tokens must not be negative",
+		`credential_policy must be one of round_robin, least_used, weighted, got "random"`,
+		"retry.jitter must be in [0, 1], got 2",
+		`context_check must be one of off, warn, reject, got "strict"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestNewFengChaoFromEnv(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+
+	path := writeConfig(t, "config.yaml", "default:\n  api_key: file-key\n  secret_key: file-secret\n  base_url: http://fengchao.invalid\n  model: glm-4\n  max_tokens: 1000\n")
+	t.Setenv(fengchao.EnvConfig, path)
+	t.Setenv(fengchao.EnvProfile, "")
+	t.Setenv(fengchao.EnvApiKey, "env-key")
+	t.Setenv(fengchao.EnvBaseUrl, server.URL)
+	t.Setenv(fengchao.EnvTemperature, "0.3")
+
+	client, err := fengchao.NewFengChaoFromEnv(
+		fengchao.WithChatCompletionOptions(fengchao.WithMaxTokens(500)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"), fengchao.WithModel("gpt-4o")); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := server.Requests()
+	// 配置文件 < 环境变量 < 客户端配置 < 请求的配置
+	if got := requests[0]; got.Model != "glm-4" || got.Temperature != 0.3 || got.MaxTokens != 500 {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+	if got := requests[1]; got.Model != "gpt-4o" {
+		t.Fatalf("got model %q, want gpt-4o", got.Model)
+	}
+	if keys := server.TokenKeys(); len(keys) != 1 || keys[0] != "env-key" {
+		t.Fatalf("got token keys %v, want env-key", keys)
+	}
+
+	t.Setenv(fengchao.EnvTimeout, "soon")
+	if _, err := fengchao.NewFengChaoFromEnv(); err == nil || !strings.Contains(err.Error(), `FENGCHAO_TIMEOUT: invalid integer "soon"`) {
+		t.Fatalf("got %v, want invalid environment error", err)
+	}
+}
+
+func TestConfigTokenFile(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	tokenPath := writeConfig(t, "token", fengchaotest.DefaultToken)
+	path := writeConfig(t, "config.toml", "[default]\ntoken_file = \""+tokenPath+"\"\nbase_url = \""+server.URL+"\"\n")
+
+	client, err := fengchao.NewFengChaoFromFile(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+	if server.TokenRequests() != 0 {
+		t.Fatalf("got %d token requests, want 0", server.TokenRequests())
+	}
+
+	if err := (&fengchao.Config{BaseUrl: server.URL}).Validate(); err == nil || !strings.Contains(err.Error(), "api_key, credentials, token_file or token_command is required") {
+		t.Fatalf("got %v, want missing credential error", err)
+	}
+}
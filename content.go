@@ -0,0 +1,204 @@
+package fengchaogo
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// 内容片段的类型
+const (
+	// ContentPartText 文本
+	ContentPartText = "text"
+	// ContentPartImageURL 图片, 可以是http地址或者base64编码的data url
+	ContentPartImageURL = "image_url"
+)
+
+// ModelModeVision 模型列表中支持图片输入的模式
+const ModelModeVision = "vision"
+
+// ContentPart 多模态消息的内容片段
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL 图片地址
+type ImageURL struct {
+	// URL http地址或者data url
+	URL string `json:"url"`
+	// Detail 图片的精细程度, 可选auto、low、high, 为空时由模型决定
+	Detail string `json:"detail,omitempty"`
+}
+
+// TextPart 创建文本片段
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: ContentPartText, Text: text}
+}
+
+// ImageURLPart 使用图片地址创建图片片段
+func ImageURLPart(url string) ContentPart {
+	return ContentPart{Type: ContentPartImageURL, ImageURL: &ImageURL{URL: url}}
+}
+
+// ImageBytesPart 使用图片内容创建图片片段, 根据内容识别MIME类型并使用base64编码为data url
+func ImageBytesPart(data []byte) (ContentPart, error) {
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return ContentPart{}, fmt.Errorf("content is not an image, detected %s", mimeType)
+	}
+	return imageDataPart(mimeType, data), nil
+}
+
+// ImageFilePart 读取图片文件创建图片片段, 根据内容识别MIME类型, 无法识别时使用扩展名
+func ImageFilePart(path string) (ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("read image file error: %w", err)
+	}
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType, _, _ = strings.Cut(mime.TypeByExtension(filepath.Ext(path)), ";")
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return ContentPart{}, fmt.Errorf("file %s is not an image", path)
+	}
+	return imageDataPart(mimeType, data), nil
+}
+
+// imageDataPart 创建base64编码的图片片段
+func imageDataPart(mimeType string, data []byte) ContentPart {
+	return ImageURLPart("data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+// NewUserMessageWithImages 生成带有图片的用户消息, 文本支持模板渲染
+func NewUserMessageWithImages(messageStr string, images ...ContentPart) lazyMessage {
+	return func() (*Message, error) {
+		template, err := template.New("").Parse(messageStr)
+		if err != nil {
+			return nil, fmt.Errorf("parse message template error: %v", err)
+		}
+		return &Message{Role: RoleUser, Parts: images, template: template}, nil
+	}
+}
+
+// contentParts 将文本和其他片段合并为内容片段
+func contentParts(text string, parts []ContentPart) []ContentPart {
+	if text == "" {
+		return parts
+	}
+	return append([]ContentPart{TextPart(text)}, parts...)
+}
+
+// decodeContent 解析字符串或者内容片段数组格式的内容, 文本片段合并为文本, 其他片段原样返回
+func decodeContent(data json.RawMessage) (string, []ContentPart, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return "", nil, nil
+	}
+	if data[0] == '"' {
+		var text string
+		err := json.Unmarshal(data, &text)
+		return text, nil, err
+	}
+	var parts []ContentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return "", nil, err
+	}
+	var texts []string
+	var others []ContentPart
+	for _, part := range parts {
+		if part.Type == ContentPartText {
+			texts = append(texts, part.Text)
+			continue
+		}
+		others = append(others, part)
+	}
+	return strings.Join(texts, "\n"), others, nil
+}
+
+// MarshalJSON 有其他片段时content为内容片段数组, 否则为字符串
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	if len(m.Parts) == 0 {
+		return json.Marshal(message(m))
+	}
+	return json.Marshal(struct {
+		message
+		Content []ContentPart `json:"content"`
+	}{message(m), contentParts(m.Content, m.Parts)})
+}
+
+// UnmarshalJSON content支持字符串和内容片段数组
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message
+	raw := struct {
+		*message
+		Content json.RawMessage `json:"content"`
+	}{message: (*message)(m)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var err error
+	m.Content, m.Parts, err = decodeContent(raw.Content)
+	return err
+}
+
+// MarshalJSON 问题有其他片段时query为内容片段数组, 否则为字符串
+func (cc ChatCompletion) MarshalJSON() ([]byte, error) {
+	type chatCompletion ChatCompletion
+	if len(cc.QueryParts) == 0 {
+		return json.Marshal(chatCompletion(cc))
+	}
+	return json.Marshal(struct {
+		chatCompletion
+		Query []ContentPart `json:"query"`
+	}{chatCompletion(cc), contentParts(cc.Query, cc.QueryParts)})
+}
+
+// UnmarshalJSON query支持字符串和内容片段数组
+func (cc *ChatCompletion) UnmarshalJSON(data []byte) error {
+	type chatCompletion ChatCompletion
+	raw := struct {
+		*chatCompletion
+		Query json.RawMessage `json:"query"`
+	}{chatCompletion: (*chatCompletion)(cc)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var err error
+	cc.Query, cc.QueryParts, err = decodeContent(raw.Query)
+	return err
+}
+
+// hasImages 请求中是否包含图片
+func (cc *ChatCompletion) hasImages() bool {
+	isImage := func(part ContentPart) bool { return part.Type == ContentPartImageURL }
+	if slices.ContainsFunc(cc.QueryParts, isImage) {
+		return true
+	}
+	return slices.ContainsFunc(cc.History, func(m *Message) bool { return slices.ContainsFunc(m.Parts, isImage) })
+}
+
+// checkVision 请求包含图片时, 根据模型列表检查模型是否支持图片输入
+// 模型不在列表中或者模型列表不可用时由服务端判断, 模型列表加载失败会被缓存, 不会每次请求都重新加载
+func (f *FengChao) checkVision(ctx context.Context, params *ChatCompletion) error {
+	if !params.hasImages() {
+		return nil
+	}
+	model := f.getModel(ctx, params.Model)
+	if model != nil && !slices.Contains(model.Modes, ModelModeVision) {
+		return fmt.Errorf("%w: model %s does not support image input", ErrUnsupportedParameter, model.ID)
+	}
+	return nil
+}
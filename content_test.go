@@ -0,0 +1,148 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+// pngHeader PNG文件头, 足够识别MIME类型
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestImageParts(t *testing.T) {
+	part, err := fengchao.ImageBytesPart(pngHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(part.ImageURL.URL, "data:image/png;base64,") {
+		t.Fatalf("got url %q", part.ImageURL.URL)
+	}
+	if _, err := fengchao.ImageBytesPart([]byte("hello")); err == nil {
+		t.Fatal("want error for non-image content")
+	}
+
+	dir := t.TempDir()
+	// 内容无法识别时使用扩展名
+	svg := filepath.Join(dir, "logo.svg")
+	if err := os.WriteFile(svg, []byte("<svg></svg>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	part, err = fengchao.ImageFilePart(svg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(part.ImageURL.URL, "data:image/svg+xml;base64,") {
+		t.Fatalf("got url %q", part.ImageURL.URL)
+	}
+	text := filepath.Join(dir, "note.txt")
+	if err := os.WriteFile(text, []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fengchao.ImageFilePart(text); err == nil {
+		t.Fatal("want error for non-image file")
+	}
+}
+
+func TestMessageContentParts(t *testing.T) {
+	message := &fengchao.Message{Role: fengchao.RoleUser, Content: "这是什么", Parts: []fengchao.ContentPart{fengchao.ImageURLPart("https://example.com/cat.png")}}
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"role":"user","content":[{"type":"text","text":"这是什么"},{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}}]}`
+	if string(data) != want {
+		t.Fatalf("got %s, want %s", data, want)
+	}
+
+	var decoded fengchao.Message
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Content != "这是什么" || len(decoded.Parts) != 1 || decoded.Parts[0].ImageURL.URL != "https://example.com/cat.png" {
+		t.Fatalf("got %+v", decoded)
+	}
+
+	// 没有其他片段时content仍然为字符串
+	data, _ = json.Marshal(&fengchao.Message{Role: fengchao.RoleUser, Content: "你好"})
+	if string(data) != `{"role":"user","content":"你好"}` {
+		t.Fatalf("got %s", data)
+	}
+}
+
+func TestChatCompletionWithImages(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{Content: "一只猫"})
+	client := server.Client()
+
+	prompt := fengchao.NewPromptTemplate(
+		fengchao.NewSystemMessage("你是一个图片助手"),
+		&fengchao.Message{Role: fengchao.RoleUser, Content: "看看这张", Parts: []fengchao.ContentPart{fengchao.ImageURLPart("https://example.com/dog.png")}},
+		fengchao.NewAssistantMessage("一只狗"),
+		fengchao.NewUserMessageWithImages("{{.Question}}", fengchao.ImageURLPart("https://example.com/cat.png")),
+	)
+	result, err := client.ChatCompletion(context.Background(), prompt,
+		fengchao.WithModel("gpt-4o"),
+		fengchao.WithParams(struct{ Question string }{"这是什么动物"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "一只猫" {
+		t.Fatalf("got %q", result.String())
+	}
+
+	request := server.LastRequest()
+	if request.Query != "这是什么动物" || len(request.QueryParts) != 1 || request.QueryParts[0].ImageURL.URL != "https://example.com/cat.png" {
+		t.Fatalf("got query %q parts %+v", request.Query, request.QueryParts)
+	}
+	if len(request.History) != 2 || request.History[0].Content != "看看这张" || len(request.History[0].Parts) != 1 {
+		t.Fatalf("got history %+v", request.History)
+	}
+
+	// 模型不支持图片时在发送前返回错误
+	_, err = client.ChatCompletion(context.Background(), prompt, fengchao.WithModel("glm-4"))
+	if !errors.Is(err, fengchao.ErrUnsupportedParameter) {
+		t.Fatalf("got %v, want ErrUnsupportedParameter", err)
+	}
+	_, err = client.ChatCompletionStream(context.Background(), prompt, fengchao.WithModel("glm-4"))
+	if !errors.Is(err, fengchao.ErrUnsupportedParameter) {
+		t.Fatalf("got %v, want ErrUnsupportedParameter", err)
+	}
+	if len(server.Requests()) != 1 {
+		t.Fatalf("got %d requests, want 1", len(server.Requests()))
+	}
+}
+
+func TestChatCompletionWithImagesModelsUnavailable(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{Content: "一只猫"})
+	var loads atomic.Int32
+	client := server.Client(fengchao.WithMiddleware(failingModels(&loads)))
+
+	// 模型列表不可用时不检查, 也不会每次请求都重新加载
+	for range 3 {
+		_, err := client.ChatCompletion(context.Background(),
+			fengchao.NewUserMessageWithImages("这是什么", fengchao.ImageURLPart("https://example.com/cat.png")),
+			fengchao.WithModel("glm-4"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("got %d model loads, want 1", n)
+	}
+	if len(server.Requests()) != 3 {
+		t.Fatalf("got %d requests, want 3", len(server.Requests()))
+	}
+}
@@ -0,0 +1,214 @@
+package fengchaogo
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Credential 鉴权凭证
+type Credential struct {
+	// ApiKey fengchao api key
+	ApiKey string `json:"api_key" yaml:"api_key" toml:"api_key"`
+	// SecretKey fengchao secret key
+	SecretKey string `json:"secret_key" yaml:"secret_key" toml:"secret_key"`
+	// Weight 权重, 只在CredentialWeighted策略下生效, 小于等于0时为1
+	Weight int `json:"weight" yaml:"weight" toml:"weight"`
+	// Source 获取token的来源, 为空时使用ApiKey和SecretKey换取token
+	Source TokenSource `json:"-" yaml:"-" toml:"-"`
+}
+
+// CredentialPolicy 凭证的选择策略
+type CredentialPolicy int
+
+const (
+	// CredentialRoundRobin 依次使用
+	CredentialRoundRobin CredentialPolicy = iota
+	// CredentialLeastUsed 使用进行中请求最少的凭证, 相同时使用累计请求最少的凭证
+	CredentialLeastUsed
+	// CredentialWeighted 按照权重平滑地分配请求
+	CredentialWeighted
+)
+
+// CredentialPoolConfig 凭证池配置
+type CredentialPoolConfig struct {
+	// Policy 选择策略
+	Policy CredentialPolicy
+	// Cooldown 鉴权失败或者被限流后暂停使用的时间, 被限流时优先使用服务端返回的Retry-After
+	Cooldown time.Duration
+}
+
+// DefaultCredentialPoolConfig 默认的凭证池配置
+var DefaultCredentialPoolConfig = CredentialPoolConfig{
+	Policy:   CredentialRoundRobin,
+	Cooldown: 30 * time.Second,
+}
+
+// CredentialUsage 凭证的使用统计
+type CredentialUsage struct {
+	// ApiKey fengchao api key
+	ApiKey string
+	// Requests 累计请求数
+	Requests int64
+	// Failures 累计失败数
+	Failures int64
+	// InFlight 进行中的请求数, 流式请求在关闭数据流后结束
+	InFlight int
+	// PromptTokens 累计输入的token数
+	PromptTokens int64
+	// CompletionTokens 累计输出的token数
+	CompletionTokens int64
+	// TotalTokens 累计消耗的token数
+	TotalTokens int64
+	// DisabledUntil 暂停使用的截止时间, 为零值时没有暂停
+	DisabledUntil time.Time
+	// LastError 最后一次导致暂停使用的错误
+	LastError error
+}
+
+// credential 凭证池中的凭证
+type credential struct {
+	Credential
+	usage         CredentialUsage
+	currentWeight int
+}
+
+// source 获取token的来源
+func (c *credential) source() TokenSource {
+	if c.Source != nil {
+		return c.Source
+	}
+	return &KeySecretTokenSource{ApiKey: c.ApiKey, SecretKey: c.SecretKey}
+}
+
+// name 日志中使用的凭证名称, 已脱敏
+func (c *credential) name() string {
+	if c.ApiKey != "" {
+		return redact(c.ApiKey)
+	}
+	return fmt.Sprintf("%T", c.Source)
+}
+
+// credentialPool 凭证池
+type credentialPool struct {
+	credentials []*credential
+	config      CredentialPoolConfig
+	next        int
+	mu          sync.Mutex
+}
+
+// newCredentialPool 创建凭证池
+func newCredentialPool(config CredentialPoolConfig, credentials []Credential) *credentialPool {
+	p := &credentialPool{config: config}
+	for _, c := range credentials {
+		if c.Weight <= 0 {
+			c.Weight = 1
+		}
+		p.credentials = append(p.credentials, &credential{Credential: c, usage: CredentialUsage{ApiKey: c.ApiKey}})
+	}
+	return p
+}
+
+// acquire 按照策略选择一个凭证, 所有凭证都暂停使用时选择最早恢复的凭证, 使用结束后需要调用release
+func (p *credentialPool) acquire() *credential {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	available := make([]*credential, 0, len(p.credentials))
+	for _, c := range p.credentials {
+		if !now.Before(c.usage.DisabledUntil) {
+			available = append(available, c)
+		}
+	}
+
+	var picked *credential
+	switch {
+	case len(available) == 0:
+		for _, c := range p.credentials {
+			if picked == nil || c.usage.DisabledUntil.Before(picked.usage.DisabledUntil) {
+				picked = c
+			}
+		}
+	case p.config.Policy == CredentialLeastUsed:
+		for _, c := range available {
+			if picked == nil || c.usage.InFlight < picked.usage.InFlight ||
+				c.usage.InFlight == picked.usage.InFlight && c.usage.Requests < picked.usage.Requests {
+				picked = c
+			}
+		}
+	case p.config.Policy == CredentialWeighted:
+		// 平滑加权轮询, 每次选择当前权重最大的凭证, 然后减去总权重
+		total := 0
+		for _, c := range available {
+			c.currentWeight += c.Weight
+			total += c.Weight
+			if picked == nil || c.currentWeight > picked.currentWeight {
+				picked = c
+			}
+		}
+		picked.currentWeight -= total
+	default:
+		for i := range p.credentials {
+			c := p.credentials[(p.next+i)%len(p.credentials)]
+			if !now.Before(c.usage.DisabledUntil) {
+				picked = c
+				p.next = (p.next + i + 1) % len(p.credentials)
+				break
+			}
+		}
+	}
+
+	picked.usage.Requests++
+	picked.usage.InFlight++
+	return picked
+}
+
+// release 结束凭证的使用, 记录消耗的token数, 鉴权失败或者被限流时暂停使用
+func (p *credentialPool) release(c *credential, usage *ChatCompletionResult, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.usage.InFlight--
+	if usage != nil {
+		c.usage.PromptTokens += int64(usage.Usage.PromptTokens)
+		c.usage.CompletionTokens += int64(usage.Usage.CompletionTokens)
+		c.usage.TotalTokens += int64(usage.Usage.TotalTokens)
+	}
+	if err == nil {
+		return
+	}
+	c.usage.Failures++
+
+	cooldown := time.Duration(0)
+	switch {
+	case errors.Is(err, ErrAuth):
+		cooldown = p.config.Cooldown
+	case errors.Is(err, ErrRateLimited):
+		cooldown = p.config.Cooldown
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			cooldown = apiErr.RetryAfter
+		}
+	}
+	if cooldown > 0 {
+		c.usage.DisabledUntil = time.Now().Add(cooldown)
+		c.usage.LastError = err
+	}
+}
+
+// usage 获取所有凭证的使用统计
+func (p *credentialPool) usage() []CredentialUsage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	usage := make([]CredentialUsage, 0, len(p.credentials))
+	for _, c := range p.credentials {
+		usage = append(usage, c.usage)
+	}
+	return usage
+}
+
+// CredentialUsage 获取所有凭证的使用统计
+func (f *FengChao) CredentialUsage() []CredentialUsage {
+	return f.credentials.usage()
+}
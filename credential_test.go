@@ -0,0 +1,98 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+func TestCredentialRoundRobin(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{Content: "ok", PromptTokens: 2, CompletionTokens: 3})
+
+	client := fengchao.NewFengChao("key-1", "secret-1", server.URL,
+		fengchao.WithCredentials(fengchao.Credential{ApiKey: "key-2", SecretKey: "secret-2"}),
+	)
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 每个凭证单独获取并缓存token
+	keys := server.TokenKeys()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"key-1", "key-2"}) {
+		t.Fatalf("got token requests %v, want one per key", keys)
+	}
+	for _, usage := range client.CredentialUsage() {
+		if usage.Requests != 2 || usage.InFlight != 0 || usage.PromptTokens != 4 || usage.CompletionTokens != 6 || usage.TotalTokens != 10 {
+			t.Fatalf("unexpected usage: %+v", usage)
+		}
+	}
+}
+
+func TestCredentialWeighted(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+
+	client := fengchao.NewFengChao("", "", server.URL,
+		fengchao.WithCredentials(
+			fengchao.Credential{ApiKey: "key-1", SecretKey: "secret-1", Weight: 3},
+			fengchao.Credential{ApiKey: "key-2", SecretKey: "secret-2", Weight: 1},
+		),
+		fengchao.WithCredentialPool(fengchao.CredentialPoolConfig{Policy: fengchao.CredentialWeighted}),
+	)
+	for i := 0; i < 8; i++ {
+		if _, err := client.QuickCompletion(context.Background(), fengchao.WithPredefinedPrompts("多译英"), fengchao.WithQuery("你好")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage := client.CredentialUsage()
+	if len(usage) != 2 || usage[0].Requests != 6 || usage[1].Requests != 2 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestCredentialCooldown(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnQuery("你好",
+		fengchaotest.Reply{HTTPStatus: http.StatusTooManyRequests, Msg: "too many requests", Header: map[string]string{"Retry-After": "60"}},
+		fengchaotest.Reply{Content: "ok"},
+	)
+
+	client := fengchao.NewFengChao("key-1", "secret-1", server.URL,
+		fengchao.WithCredentials(fengchao.Credential{ApiKey: "key-2", SecretKey: "secret-2"}),
+		fengchao.WithCredentialPool(fengchao.CredentialPoolConfig{Policy: fengchao.CredentialLeastUsed}),
+		fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}),
+	)
+	ctx := context.Background()
+	if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err == nil {
+		t.Fatal("want rate limited error")
+	}
+	for i := 0; i < 2; i++ {
+		reader, err := client.ChatCompletionStream(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range reader.Stream() {
+		}
+	}
+
+	// 被限流的凭证暂停使用, 之后的请求都使用另一个凭证
+	usage := client.CredentialUsage()
+	if usage[0].Requests != 1 || usage[0].Failures != 1 || usage[0].DisabledUntil.IsZero() || usage[0].LastError == nil {
+		t.Fatalf("unexpected usage of throttled key: %+v", usage[0])
+	}
+	if usage[1].Requests != 2 || usage[1].InFlight != 0 || !usage[1].DisabledUntil.IsZero() {
+		t.Fatalf("unexpected usage of available key: %+v", usage[1])
+	}
+}
@@ -0,0 +1,299 @@
+package fengchaogo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint 服务地址
+type Endpoint struct {
+	// URL 服务地址, 例如http://fengchao.api
+	URL string `json:"url" yaml:"url" toml:"url"`
+	// Priority 优先级, 数值越小越优先, 相同优先级按照添加的顺序
+	Priority int `json:"priority" yaml:"priority" toml:"priority"`
+}
+
+// HealthCheck 不可用服务地址的健康检查配置
+type HealthCheck struct {
+	// Interval 检查间隔, 小于等于0时不进行检查, 只在请求成功时恢复
+	Interval time.Duration
+	// MaxInterval 最大检查间隔, 检查失败时间隔加倍直到MaxInterval, 小于等于Interval时使用固定的间隔
+	MaxInterval time.Duration
+	// Timeout 单次检查的超时时间
+	Timeout time.Duration
+	// Path 检查的路径, 响应状态码小于500即认为恢复
+	Path string
+}
+
+// DefaultHealthCheck 默认的健康检查配置
+var DefaultHealthCheck = HealthCheck{
+	Interval:    10 * time.Second,
+	MaxInterval: 5 * time.Minute,
+	Timeout:     time.Duration(BasicRequestTimeout) * time.Second,
+	Path:        "/models/",
+}
+
+// EndpointStatus 服务地址的状态
+type EndpointStatus struct {
+	Endpoint
+	// Healthy 是否可用
+	Healthy bool
+	// Failures 连续失败的次数
+	Failures int
+	// LastError 最后一次失败的原因
+	LastError error
+	// LastFailure 最后一次失败的时间
+	LastFailure time.Time
+}
+
+// endpoint 服务地址, 每个服务地址按照凭证单独维护token
+type endpoint struct {
+	Endpoint
+	auth        map[*credential]*authManager
+	healthy     bool
+	failures    int
+	lastError   error
+	lastFailure time.Time
+	probing     bool
+}
+
+// endpointPool 服务地址池
+type endpointPool struct {
+	endpoints []*endpoint
+	check     HealthCheck
+	mu        sync.Mutex
+
+	// ctx 关闭时取消, 用于停止健康检查
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+	probes sync.WaitGroup
+}
+
+// newEndpointPool 创建服务地址池, baseUrl作为优先级为0的服务地址
+func newEndpointPool(baseUrl string, endpoints []Endpoint, check HealthCheck) *endpointPool {
+	p := &endpointPool{check: check}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	if baseUrl != "" {
+		endpoints = append([]Endpoint{{URL: baseUrl}}, endpoints...)
+	}
+	for _, e := range endpoints {
+		e.URL = strings.TrimRight(e.URL, "/")
+		p.endpoints = append(p.endpoints, &endpoint{Endpoint: e, auth: make(map[*credential]*authManager), healthy: true})
+	}
+	sort.SliceStable(p.endpoints, func(i, j int) bool {
+		return p.endpoints[i].Priority < p.endpoints[j].Priority
+	})
+	return p
+}
+
+// candidates 获取本次请求依次尝试的服务地址, 可用的在前, 不可用的作为最后的选择
+func (p *endpointPool) candidates() []*endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	candidates := make([]*endpoint, 0, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		if ep.healthy {
+			candidates = append(candidates, ep)
+		}
+	}
+	for _, ep := range p.endpoints {
+		if !ep.healthy {
+			candidates = append(candidates, ep)
+		}
+	}
+	return candidates
+}
+
+// succeed 记录请求成功, 返回服务地址是否从不可用中恢复
+func (p *endpointPool) succeed(ep *endpoint) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	recovered := !ep.healthy
+	ep.healthy = true
+	ep.failures = 0
+	return recovered
+}
+
+// fail 记录请求失败并将服务地址标记为不可用, 返回是否需要开始健康检查
+func (p *endpointPool) fail(ep *endpoint, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ep.healthy = false
+	ep.failures++
+	ep.lastError = redactError(err)
+	ep.lastFailure = time.Now()
+	if ep.probing || p.closed || p.check.Interval <= 0 || len(p.endpoints) < 2 {
+		return false
+	}
+	ep.probing = true
+	p.probes.Add(1)
+	return true
+}
+
+// close 停止所有的健康检查并等待退出
+func (p *endpointPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cancel()
+	p.mu.Unlock()
+	p.probes.Wait()
+}
+
+// status 获取所有服务地址的状态
+func (p *endpointPool) status() []EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		status = append(status, EndpointStatus{
+			Endpoint:    ep.Endpoint,
+			Healthy:     ep.healthy,
+			Failures:    ep.failures,
+			LastError:   ep.lastError,
+			LastFailure: ep.lastFailure,
+		})
+	}
+	return status
+}
+
+// Endpoints 获取所有服务地址的状态, 按照优先级排序
+func (f *FengChao) Endpoints() []EndpointStatus {
+	return f.endpoints.status()
+}
+
+// doFailover 按照优先级在服务地址上发送请求, 遇到连接错误或者5xx时切换到下一个服务地址
+// cred不为空时会先使用凭证获取对应服务地址的token
+func (f *FengChao) doFailover(ctx context.Context, cred *credential, newRequest func(token string) *Request) (*Response, error) {
+	candidates := f.endpoints.candidates()
+	for i, ep := range candidates {
+		resp, err := f.doEndpoint(ctx, ep, cred, newRequest)
+		if !shouldFailover(ctx, resp, err) {
+			if f.endpoints.succeed(ep) {
+				f.logger.Info("fengchao endpoint recovered", "endpoint", ep.URL)
+			}
+			return resp, err
+		}
+
+		// 获取token失败时错误中可能包含api_key和secret_key
+		cause := redactError(err)
+		if cause == nil {
+			cause = handleErrorResponse(resp, "")
+		}
+		if f.endpoints.fail(ep, cause) {
+			go f.probe(ep)
+		}
+		if i == len(candidates)-1 {
+			return resp, err
+		}
+		f.logger.Warn("fengchao endpoint failover", "endpoint", ep.URL, "next", candidates[i+1].URL, "error", cause)
+	}
+	return nil, errors.New("no available endpoint")
+}
+
+// doEndpoint 在指定的服务地址上发送请求, token被服务端拒绝时作废token, 刷新后重新发送一次
+func (f *FengChao) doEndpoint(ctx context.Context, ep *endpoint, cred *credential, newRequest func(token string) *Request) (*Response, error) {
+	if cred == nil {
+		req := newRequest("")
+		req.Endpoint = ep.URL
+		return f.do(ctx, req)
+	}
+
+	for replayed := false; ; replayed = true {
+		token, err := f.getAuthToken(ctx, ep, cred)
+		if err != nil {
+			// 调用方取消或者超时不是鉴权失败, 不需要暂停使用凭证
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w, %w", ErrAuth, err)
+		}
+		req := newRequest(token)
+		req.Endpoint = ep.URL
+		resp, err := f.do(ctx, req)
+		if err != nil || replayed || !isAuthRejected(resp) {
+			return resp, err
+		}
+		f.logger.Warn("fengchao token rejected, refreshing", "endpoint", ep.URL, "credential", cred.name(), "http_status", resp.StatusCode)
+		f.authFor(ep, cred).invalidate(token)
+	}
+}
+
+// isAuthRejected 响应是否为鉴权失败
+func isAuthRejected(resp *Response) bool {
+	if resp.StatusCode != http.StatusOK {
+		return errors.Is(handleErrorResponse(resp, ""), ErrAuth)
+	}
+	return resp.Result != nil && errors.Is(resp.Result.HandleError(), ErrAuth)
+}
+
+// shouldFailover 是否需要切换服务地址, 调用方取消或者超时时不切换
+func shouldFailover(ctx context.Context, resp *Response, err error) bool {
+	if err == nil {
+		return resp != nil && resp.StatusCode >= 500
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus >= 500
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
+// probe 对不可用的服务地址进行健康检查, 恢复或者客户端关闭后退出, 检查失败时按照MaxInterval退避
+func (f *FengChao) probe(ep *endpoint) {
+	pool := f.endpoints
+	defer pool.probes.Done()
+	check := pool.check
+	interval := check.Interval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-pool.ctx.Done():
+			pool.mu.Lock()
+			ep.probing = false
+			pool.mu.Unlock()
+			return
+		case <-timer.C:
+		}
+		ctx, cancel := context.WithTimeout(pool.ctx, check.Timeout)
+		resp, err := f.client.R().SetContext(ctx).Get(ep.URL + check.Path)
+		cancel()
+
+		passed := err == nil && resp.StatusCode() < 500
+		pool.mu.Lock()
+		// 期间有请求成功时已经恢复, 不需要再检查
+		recovered := !ep.healthy && passed
+		if ep.healthy || passed {
+			ep.healthy = true
+			ep.failures = 0
+			ep.probing = false
+		}
+		done := !ep.probing
+		pool.mu.Unlock()
+
+		if recovered {
+			f.logger.Info("fengchao endpoint recovered", "endpoint", ep.URL)
+		}
+		if done {
+			return
+		}
+		if check.MaxInterval > interval {
+			interval = min(interval*2, check.MaxInterval)
+		}
+		timer.Reset(interval)
+	}
+}
@@ -0,0 +1,214 @@
+package fengchaogo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+func TestEndpointFailover(t *testing.T) {
+	primary := fengchaotest.NewServer()
+	defer primary.Close()
+	primary.Token = "primary-token"
+	primary.SetDefault(fengchaotest.Reply{HTTPStatus: http.StatusBadGateway, Msg: "bad gateway"})
+
+	secondary := fengchaotest.NewServer()
+	defer secondary.Close()
+	secondary.Token = "secondary-token"
+	secondary.SetDefault(fengchaotest.Reply{Content: "secondary"})
+
+	client := fengchao.NewFengChao("key", "secret", primary.URL,
+		fengchao.WithEndpoints(fengchao.Endpoint{URL: secondary.URL, Priority: 1}),
+		fengchao.WithHealthCheck(fengchao.HealthCheck{}),
+		fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}),
+	)
+
+	result, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "secondary" {
+		t.Fatalf("got %q, want reply from secondary", result.String())
+	}
+	// 每个服务地址使用各自签发的token
+	if primary.TokenRequests() != 1 || secondary.TokenRequests() != 1 {
+		t.Fatalf("got token requests %d/%d, want 1/1", primary.TokenRequests(), secondary.TokenRequests())
+	}
+
+	status := client.Endpoints()
+	if len(status) != 2 || status[0].Healthy || !status[1].Healthy || status[0].Failures != 1 {
+		t.Fatalf("unexpected endpoint status: %+v", status)
+	}
+
+	// 不可用的服务地址排在后面, 不再先请求
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.Requests()) != 1 || len(secondary.Requests()) != 2 {
+		t.Fatalf("got requests %d/%d, want 1/2", len(primary.Requests()), len(secondary.Requests()))
+	}
+}
+
+func TestEndpointConnectionError(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	server := fengchaotest.NewServer()
+	defer server.Close()
+
+	client := fengchao.NewFengChao("key", "secret", down.URL,
+		fengchao.WithEndpoints(fengchao.Endpoint{URL: server.URL, Priority: 1}),
+		fengchao.WithHealthCheck(fengchao.HealthCheck{}),
+		fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}),
+	)
+
+	reader, err := client.ChatCompletionStream(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if len(server.Requests()) != 1 {
+		t.Fatalf("got %d requests, want 1", len(server.Requests()))
+	}
+	if models := client.GetAvailableModels(); len(models) != len(fengchaotest.DefaultModels) {
+		t.Fatalf("got %d models, want %d", len(models), len(fengchaotest.DefaultModels))
+	}
+}
+
+func TestEndpointAllFailed(t *testing.T) {
+	primary := fengchaotest.NewServer()
+	defer primary.Close()
+	primary.SetDefault(fengchaotest.Reply{HTTPStatus: http.StatusServiceUnavailable, Msg: "unavailable"})
+	secondary := fengchaotest.NewServer()
+	defer secondary.Close()
+	secondary.SetDefault(fengchaotest.Reply{HTTPStatus: http.StatusInternalServerError, Msg: "internal error"})
+
+	client := fengchao.NewFengChao("key", "secret", primary.URL,
+		fengchao.WithEndpoints(fengchao.Endpoint{URL: secondary.URL, Priority: 1}),
+		fengchao.WithHealthCheck(fengchao.HealthCheck{}),
+		fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}),
+	)
+
+	_, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"))
+	var apiErr *fengchao.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatus != http.StatusInternalServerError {
+		t.Fatalf("got %v, want error from the last endpoint", err)
+	}
+}
+
+func TestEndpointHealthCheck(t *testing.T) {
+	primary := fengchaotest.NewServer()
+	defer primary.Close()
+	primary.OnQuery("你好", fengchaotest.Reply{HTTPStatus: http.StatusBadGateway}, fengchaotest.Reply{Content: "primary"})
+	secondary := fengchaotest.NewServer()
+	defer secondary.Close()
+
+	client := fengchao.NewFengChao("key", "secret", primary.URL,
+		fengchao.WithEndpoints(fengchao.Endpoint{URL: secondary.URL, Priority: 1}),
+		fengchao.WithHealthCheck(fengchao.HealthCheck{Interval: 10 * time.Millisecond, Timeout: time.Second, Path: "/models/"}),
+		fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}),
+	)
+	defer client.Close()
+
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !client.Endpoints()[0].Healthy {
+		if time.Now().After(deadline) {
+			t.Fatal("primary endpoint was not recovered by health check")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	result, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "primary" {
+		t.Fatalf("got %q, want reply from recovered primary", result.String())
+	}
+}
+
+func TestEndpointStatusRedactsSecret(t *testing.T) {
+	const secretKey = "secret-key-1234567890"
+	// 关闭的服务地址, 获取token时返回连接错误
+	primary := httptest.NewServer(http.NotFoundHandler())
+	primary.Close()
+	secondary := fengchaotest.NewServer()
+	defer secondary.Close()
+	secondary.SetDefault(fengchaotest.Reply{Content: "secondary"})
+
+	buffer := &bytes.Buffer{}
+	client := fengchao.NewFengChao("key", secretKey, primary.URL,
+		fengchao.WithEndpoints(fengchao.Endpoint{URL: secondary.URL, Priority: 1}),
+		fengchao.WithHealthCheck(fengchao.HealthCheck{}),
+		fengchao.WithLogger(fengchao.NewSlogLogger(slog.New(slog.NewTextHandler(buffer, nil)))),
+	)
+	defer client.Close()
+
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewUserMessage("你好")); err != nil {
+		t.Fatal(err)
+	}
+	status := client.Endpoints()[0]
+	if status.Healthy || status.LastError == nil {
+		t.Fatalf("got status %+v, want failed primary", status)
+	}
+	if strings.Contains(status.LastError.Error(), secretKey) {
+		t.Errorf("last error contains secret: %v", status.LastError)
+	}
+	if !strings.Contains(buffer.String(), "fengchao endpoint failover") || strings.Contains(buffer.String(), secretKey) {
+		t.Errorf("log output should contain failover without secret: %s", buffer)
+	}
+}
+
+func TestEndpointHealthCheckBackoffAndClose(t *testing.T) {
+	var probes atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/models/" {
+			probes.Add(1)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+	secondary := fengchaotest.NewServer()
+	defer secondary.Close()
+	secondary.SetDefault(fengchaotest.Reply{Content: "secondary"})
+
+	client := fengchao.NewFengChao("key", "secret", primary.URL,
+		fengchao.WithEndpoints(fengchao.Endpoint{URL: secondary.URL, Priority: 1}),
+		fengchao.WithHealthCheck(fengchao.HealthCheck{Interval: 5 * time.Millisecond, MaxInterval: 40 * time.Millisecond, Timeout: time.Second, Path: "/models/"}),
+	)
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewUserMessage("你好")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 固定间隔时约60次, 退避后约10次
+	time.Sleep(300 * time.Millisecond)
+	if n := probes.Load(); n == 0 || n > 20 {
+		t.Fatalf("got %d probes, want backoff", n)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	closed := probes.Load()
+	time.Sleep(100 * time.Millisecond)
+	if n := probes.Load(); n != closed {
+		t.Fatalf("got %d probes after close, want %d", n, closed)
+	}
+	if client.Endpoints()[0].Healthy {
+		t.Fatal("primary should stay unhealthy after close")
+	}
+}
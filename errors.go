@@ -0,0 +1,132 @@
+package fengchaogo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 错误分类, 可以使用errors.Is判断
+var (
+	// ErrTimeout 请求超时
+	ErrTimeout = errors.New("request timeout")
+	// ErrAuth 认证失败
+	ErrAuth = errors.New("auth failed")
+	// ErrRateLimited 请求被限流
+	ErrRateLimited = errors.New("rate limited")
+	// ErrSensitiveContent 内容触发了敏感词检测
+	ErrSensitiveContent = errors.New("sensitive content")
+	// ErrContextLength 输入超过模型的上下文长度
+	ErrContextLength = errors.New("context length exceeded")
+	// ErrModelUnavailable 模型不存在或者不可用
+	ErrModelUnavailable = errors.New("model unavailable")
+	// ErrBatchSizeExceeded 批量请求超过最大数量
+	ErrBatchSizeExceeded = errors.New("batch size exceeded")
+	// ErrInvalidParameter 请求参数超出取值范围
+	ErrInvalidParameter = errors.New("invalid parameter")
+	// ErrUnsupportedParameter 模型不支持的请求参数
+	ErrUnsupportedParameter = errors.New("unsupported parameter")
+)
+
+// APIError 服务端返回的错误, 可以使用errors.As获取, 并通过errors.Is判断错误分类
+type APIError struct {
+	// HTTPStatus HTTP状态码
+	HTTPStatus int
+	// Status 业务状态码
+	Status int
+	// Msg 错误信息
+	Msg string
+	// RequestID 请求ID
+	RequestID string
+	// Body 原始响应内容
+	Body []byte
+	// RetryAfter 服务端要求的重试等待时间
+	RetryAfter time.Duration
+
+	// kind 错误分类, 没有匹配的分类时为空
+	kind error
+}
+
+// Error 错误信息
+func (e *APIError) Error() string {
+	if e.HTTPStatus != 0 && e.HTTPStatus != http.StatusOK {
+		return fmt.Sprintf("api error: [http %d]%s", e.HTTPStatus, e.Msg)
+	}
+	return fmt.Sprintf("api error: [%d]%s", e.Status, e.Msg)
+}
+
+// Unwrap 返回错误分类, 用于errors.Is
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// newAPIError 创建服务端错误并进行分类
+func newAPIError(httpStatus int, status int, msg string, requestID string, body []byte) *APIError {
+	e := &APIError{
+		HTTPStatus: httpStatus,
+		Status:     status,
+		Msg:        msg,
+		RequestID:  requestID,
+		Body:       body,
+	}
+	e.kind = classifyAPIError(e)
+	return e
+}
+
+// 用于识别错误分类的关键字
+var (
+	sensitiveKeywords        = []string{"sensitive", "敏感", "违规", "不合规"}
+	contextLengthKeywords    = []string{"context length", "context_length", "maximum context", "too long", "max_input_token", "超出最大长度", "超过最大长度", "长度超"}
+	modelUnavailableKeywords = []string{"model not found", "model_not_found", "no such model", "model is not available", "unavailable", "模型不存在", "模型不可用", "暂不可用"}
+	authKeywords             = []string{"unauthorized", "invalid token", "token expired", "token is expired", "认证失败", "token无效", "token过期"}
+	rateLimitKeywords        = []string{"rate limit", "too many requests", "限流", "请求过于频繁"}
+)
+
+// containsAny 判断信息中是否包含任意一个关键字
+func containsAny(msg string, keywords []string) bool {
+	msg = strings.ToLower(msg)
+	for _, keyword := range keywords {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyAPIError 根据状态码和错误信息对错误进行分类
+func classifyAPIError(e *APIError) error {
+	statuses := []int{e.HTTPStatus, e.Status}
+	for _, status := range statuses {
+		switch status {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ErrAuth
+		case http.StatusTooManyRequests:
+			return ErrRateLimited
+		case http.StatusRequestEntityTooLarge:
+			return ErrContextLength
+		}
+	}
+
+	switch {
+	case containsAny(e.Msg, sensitiveKeywords):
+		return ErrSensitiveContent
+	case containsAny(e.Msg, contextLengthKeywords):
+		return ErrContextLength
+	case containsAny(e.Msg, rateLimitKeywords):
+		return ErrRateLimited
+	case containsAny(e.Msg, authKeywords):
+		return ErrAuth
+	case containsAny(e.Msg, modelUnavailableKeywords):
+		return ErrModelUnavailable
+	}
+
+	for _, status := range statuses {
+		switch status {
+		case http.StatusNotFound, http.StatusServiceUnavailable:
+			return ErrModelUnavailable
+		}
+	}
+	return nil
+}
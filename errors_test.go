@@ -0,0 +1,93 @@
+package fengchaogo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_classifyAPIError(t *testing.T) {
+	tests := []struct {
+		name       string
+		httpStatus int
+		status     int
+		msg        string
+		want       error
+	}{
+		{"unauthorized", http.StatusUnauthorized, 0, "", ErrAuth},
+		{"too many requests", http.StatusTooManyRequests, 0, "", ErrRateLimited},
+		{"business rate limit", http.StatusOK, 429, "请求过于频繁", ErrRateLimited},
+		{"sensitive", http.StatusOK, 500, "输入内容包含敏感词", ErrSensitiveContent},
+		{"context length", http.StatusBadRequest, 0, "This model's maximum context length is 8192 tokens", ErrContextLength},
+		{"model unavailable", http.StatusOK, 500, "模型不存在", ErrModelUnavailable},
+		{"service unavailable", http.StatusServiceUnavailable, 0, "", ErrModelUnavailable},
+		{"unknown", http.StatusBadRequest, 0, "bad request", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newAPIError(tt.httpStatus, tt.status, tt.msg, "abc", nil)
+			if got := err.Unwrap(); got != tt.want {
+				t.Errorf("classifyAPIError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFengChao_ChatCompletionErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(map[string]any{"status": 200, "token": "token"})
+			return
+		}
+		var params ChatCompletion
+		json.NewDecoder(r.Body).Decode(&params)
+		switch params.Query {
+		case "throttle":
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"detail":"too many requests"}`)
+		case "sensitive":
+			json.NewEncoder(w).Encode(map[string]any{"request_id": params.RequestID, "status": 500, "msg": "sensitive content detected"})
+		case "slow":
+			time.Sleep(1500 * time.Millisecond)
+		case "stream":
+			fmt.Fprint(w, "event: error\ndata: {\"status\":500,\"msg\":\"模型不存在\"}\n\n")
+		}
+	}))
+	defer server.Close()
+	client := NewFengChao("key", "secret", server.URL)
+	ctx := context.Background()
+
+	_, err := client.ChatCompletion(ctx, NewUserMessage("throttle"), WithRequestID("abc"))
+	var apiErr *APIError
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &apiErr) {
+		t.Fatalf("ChatCompletion() error = %v, want %v", err, ErrRateLimited)
+	}
+	if apiErr.HTTPStatus != http.StatusTooManyRequests || apiErr.Msg != "too many requests" || apiErr.RequestID != "abc" || len(apiErr.Body) == 0 {
+		t.Errorf("APIError = %+v", apiErr)
+	}
+
+	_, err = client.QuickCompletion(ctx, WithPredefinedPrompts("多译英"), WithQuery("sensitive"))
+	if !errors.Is(err, ErrSensitiveContent) || !errors.As(err, &apiErr) || apiErr.Status != 500 {
+		t.Errorf("QuickCompletion() error = %v, want %v", err, ErrSensitiveContent)
+	}
+
+	_, err = client.ChatCompletion(ctx, NewUserMessage("slow"), WithTimeout(1))
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ChatCompletion() error = %v, want %v", err, ErrTimeout)
+	}
+
+	reader, err := client.ChatCompletionStream(ctx, NewUserMessage("stream"))
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	defer reader.Close()
+	if _, _, err := reader.Read(); !errors.Is(err, ErrModelUnavailable) {
+		t.Errorf("Read() error = %v, want %v", err, ErrModelUnavailable)
+	}
+}
@@ -9,7 +9,14 @@ import (
 	fengchaogo "github.com/ijiwei/fengchao-go"
 )
 
-var client = fengchaogo.NewFengChao(os.Getenv("FENGCHAO_KEY"), os.Getenv("FENGCHAO_SECRET"), os.Getenv("FENGCHAO_BASE_URL"))
+// client 通过FENGCHAO_KEY、FENGCHAO_SECRET、FENGCHAO_BASE_URL等环境变量创建
+var client = func() *fengchaogo.FengChao {
+	client, err := fengchaogo.NewFengChaoFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	return client
+}()
 
 var systemMessage = fengchaogo.NewMessage(fengchaogo.RoleSystem, `你是一名善于理解问题的助手，你要按照以下的规则与用户对话:
 1. 采用风趣幽默的回答，适当添加Emoji来让回答更加形象
@@ -6,12 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
 
 	fengchao "github.com/ijiwei/fengchao-go"
 )
 
-var client = fengchao.NewFengChao(os.Getenv("FENGCHAO_KEY"), os.Getenv("FENGCHAO_SECRET"), os.Getenv("FENGCHAO_BASE_URL"))
+// client 通过FENGCHAO_KEY、FENGCHAO_SECRET、FENGCHAO_BASE_URL等环境变量创建
+var client = func() *fengchao.FengChao {
+	client, err := fengchao.NewFengChaoFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	return client
+}()
 
 const systemPrompt = `
 你是一名有多年经验的文字内容创作者，你的工作内容包含：
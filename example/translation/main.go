@@ -94,7 +94,14 @@ For each step of the translation process, output your results within the appropr
 Remember to consistently use the provided glossary for technical terms throughout your translation. Ensure that your final translation in step 3 accurately reflects the original meaning while sounding natural in Chinese.
 `
 
-var client = fengchao.NewFengChao(os.Getenv("FENGCHAO_KEY"), os.Getenv("FENGCHAO_SECRET"), os.Getenv("FENGCHAO_BASE_URL"))
+// client 通过FENGCHAO_KEY、FENGCHAO_SECRET、FENGCHAO_BASE_URL等环境变量创建
+var client = func() *fengchao.FengChao {
+	client, err := fengchao.NewFengChaoFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	return client
+}()
 
 func main() {
 	// 定义命令行参数
@@ -0,0 +1,109 @@
+package fengchaogo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultFallbackErrors 默认切换到备用模型的错误分类: 模型不可用、限流、超时、敏感内容以及熔断
+var DefaultFallbackErrors = []error{ErrModelUnavailable, ErrRateLimited, ErrTimeout, ErrSensitiveContent, ErrCircuitOpen}
+
+// ModelAttempt 使用一个模型的请求记录
+type ModelAttempt struct {
+	// Model 模型
+	Model string
+	// RequestID 请求ID, 每个模型使用不同的请求ID
+	RequestID string
+	// Err 请求的错误, 成功时为空
+	Err error
+	// Duration 请求耗时, 包括重试
+	Duration time.Duration
+}
+
+// FallbackError 设置备用模型时请求失败的错误, 包含每个模型的请求记录
+// 可以使用errors.Is和errors.As判断每个模型的错误
+type FallbackError struct {
+	Attempts []ModelAttempt
+}
+
+// Error 错误信息
+func (e *FallbackError) Error() string {
+	messages := make([]string, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		messages = append(messages, fmt.Sprintf("%s: %v", attempt.Model, attempt.Err))
+	}
+	return fmt.Sprintf("all %d models failed: %s", len(e.Attempts), strings.Join(messages, "; "))
+}
+
+// Unwrap 返回每个模型的错误
+func (e *FallbackError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		errs = append(errs, attempt.Err)
+	}
+	return errs
+}
+
+// WithFallbackModels 设置备用模型, 请求失败且错误属于切换的错误分类时, 按照顺序使用下一个模型发送相同的消息
+func WithFallbackModels(models ...string) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.fallbackModels = models
+	}
+}
+
+// WithFallbackOn 设置切换到备用模型的错误分类, 使用errors.Is判断, 为空时使用DefaultFallbackErrors
+func WithFallbackOn(errs ...error) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.fallbackOn = errs
+	}
+}
+
+// shouldFallback 判断错误是否需要切换到备用模型
+func (cc *ChatCompletion) shouldFallback(err error) bool {
+	fallbackOn := cc.fallbackOn
+	if len(fallbackOn) == 0 {
+		fallbackOn = DefaultFallbackErrors
+	}
+	for _, target := range fallbackOn {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// withFallback 依次使用主模型和备用模型调用call, 直到成功或者错误不需要切换
+// 没有设置备用模型或者主模型的错误不需要切换时直接返回call的结果, 使用过备用模型并且失败时返回FallbackError
+func withFallback[R any](ctx context.Context, f *FengChao, params *ChatCompletion, call func(params *ChatCompletion) (R, error)) (R, []ModelAttempt, error) {
+	if len(params.fallbackModels) == 0 {
+		result, err := call(params)
+		return result, nil, err
+	}
+
+	models := append([]string{params.Model}, params.fallbackModels...)
+	var attempts []ModelAttempt
+	for i := 0; ; i++ {
+		model, current := models[i], params
+		if i > 0 {
+			current = params.Clone()
+			current.Model = model
+		}
+		start := time.Now()
+		result, err := call(current)
+		attempts = append(attempts, ModelAttempt{Model: model, RequestID: current.RequestID, Err: err, Duration: time.Since(start)})
+		if err == nil {
+			return result, attempts, nil
+		}
+		if ctx.Err() != nil || i == len(models)-1 || !params.shouldFallback(err) {
+			// 没有使用备用模型时返回原始的错误
+			if i == 0 {
+				return result, attempts, err
+			}
+			return result, attempts, &FallbackError{Attempts: attempts}
+		}
+		f.logger.Warn("fengchao model failed, falling back", "request_id", current.RequestID, "model", model, "fallback_model", models[i+1], "error", err)
+	}
+}
@@ -0,0 +1,99 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+func TestFallbackModels(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnModel("gpt-4o", fengchaotest.Reply{HTTPStatus: http.StatusServiceUnavailable, Msg: "model is not available"})
+	server.OnModel("glm-4", fengchaotest.Reply{Status: 500, Msg: "内容包含敏感信息"})
+	server.SetDefault(fengchaotest.Reply{Content: "你好"})
+	client := server.Client()
+
+	result, err := client.ChatCompletion(context.Background(),
+		fengchao.NewUserMessage("你好"),
+		fengchao.WithModel("gpt-4o"),
+		fengchao.WithFallbackModels("glm-4", "ERNIE-Bot-4"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "你好" || result.Model != "ERNIE-Bot-4" {
+		t.Fatalf("got %q from %q", result.String(), result.Model)
+	}
+	if len(result.Attempts) != 3 ||
+		!errors.Is(result.Attempts[0].Err, fengchao.ErrModelUnavailable) ||
+		!errors.Is(result.Attempts[1].Err, fengchao.ErrSensitiveContent) ||
+		result.Attempts[2].Err != nil {
+		t.Fatalf("got attempts %+v", result.Attempts)
+	}
+
+	// 每个模型发送相同的消息, 使用不同的请求ID
+	requests := server.Requests()
+	if len(requests) != 3 || requests[2].Query != "你好" || requests[0].RequestID == requests[2].RequestID {
+		t.Fatalf("got requests %+v", requests)
+	}
+}
+
+func TestFallbackOn(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnModel("gpt-4o", fengchaotest.Reply{Status: 500, Msg: "内容包含敏感信息"})
+	server.SetDefault(fengchaotest.Reply{Content: "你好"})
+	client := server.Client()
+
+	// 敏感内容不在切换的错误分类中, 不使用备用模型
+	_, err := client.ChatCompletion(context.Background(),
+		fengchao.NewUserMessage("你好"),
+		fengchao.WithModel("gpt-4o"),
+		fengchao.WithFallbackModels("glm-4"),
+		fengchao.WithFallbackOn(fengchao.ErrModelUnavailable),
+	)
+	// 只请求了主模型时返回原始的错误
+	var fallbackErr *fengchao.FallbackError
+	if errors.As(err, &fallbackErr) || !errors.Is(err, fengchao.ErrSensitiveContent) {
+		t.Fatalf("got %v, want the original error", err)
+	}
+	var apiErr *fengchao.APIError
+	if !errors.As(err, &apiErr) || apiErr.Msg != "内容包含敏感信息" {
+		t.Fatalf("got %v, want APIError", err)
+	}
+	if len(server.Requests()) != 1 {
+		t.Fatalf("got %d requests, want 1", len(server.Requests()))
+	}
+}
+
+func TestFallbackModelsStream(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnModel("gpt-4o", fengchaotest.Reply{HTTPStatus: http.StatusTooManyRequests, Msg: "too many requests"})
+	server.SetDefault(fengchaotest.Reply{Chunks: []string{"你", "好"}})
+	client := server.Client()
+
+	reader, err := client.ChatCompletionStream(context.Background(),
+		fengchao.NewUserMessage("你好"),
+		fengchao.WithModel("gpt-4o"),
+		fengchao.WithFallbackModels("glm-4"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := ""
+	for msg := range reader.Stream() {
+		content += msg.String()
+	}
+	if content != "你好" {
+		t.Fatalf("got %q", content)
+	}
+	if request := server.LastRequest(); request.Model != "glm-4" {
+		t.Fatalf("got model %q", request.Model)
+	}
+}
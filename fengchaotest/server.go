@@ -0,0 +1,422 @@
+// Package fengchaotest 提供进程内的FengChao测试服务, 实现了/token、/models/和/chat/接口,
+// 可以按照模型或者问题编排响应、注入错误和延迟、输出分片或者缓慢的数据流, 并校验收到的请求
+package fengchaotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+)
+
+// DefaultToken 测试服务返回的token
+const DefaultToken = "fengchaotest-token"
+
+// DefaultModels 测试服务默认的模型列表
+var DefaultModels = []fengchao.Model{
+	{ID: "ERNIE-Bot-4", OwnedBy: "baidu", MaxInputToken: 8000, MaxOutputToken: 2000, Modes: []string{"invoke", "stream"}},
+	{ID: "glm-4", OwnedBy: "zhipu", MaxInputToken: 128000, MaxOutputToken: 4000, Modes: []string{"invoke", "stream"}},
+	{ID: "gpt-4o", OwnedBy: "openai", MaxInputToken: 128000, MaxOutputToken: 4000, Modes: []string{"invoke", "stream", "vision"}},
+}
+
+// Reply 编排的响应
+type Reply struct {
+	// Content 生成的内容
+	Content string
+	// Choices 请求设置n大于1时每个结果的内容, 为空时每个结果都使用Content
+	Choices []string
+	// ToolCalls 模型调用的工具, 不为空时FinishReason默认为tool_calls
+	// 流式响应中每个工具调用拆分为名称和两段参数三个分片输出
+	ToolCalls []fengchao.ToolCall
+	// Chunks 流式响应的分片, 为空时将Content作为一个分片
+	Chunks []string
+	// FinishReason 结束原因, 为空时为stop
+	FinishReason string
+	// PromptTokens 输入的token数
+	PromptTokens int
+	// CompletionTokens 输出的token数
+	CompletionTokens int
+
+	// Status 业务状态码, 为0时为200
+	Status int
+	// Msg 业务错误信息
+	Msg string
+	// HTTPStatus HTTP状态码, 为0时为200, 不为200时返回{"detail": Msg}
+	HTTPStatus int
+	// Header 额外的响应头, 例如Retry-After
+	Header map[string]string
+
+	// Latency 返回响应之前的延迟
+	Latency time.Duration
+	// ChunkDelay 流式响应每个分片之间的延迟
+	ChunkDelay time.Duration
+	// StreamError 流式响应在输出分片之后通过error事件返回Status和Msg
+	StreamError bool
+}
+
+// Matcher 判断请求是否使用编排的响应
+type Matcher func(req *fengchao.ChatCompletion) bool
+
+// rule 编排的规则, 响应按照顺序使用, 最后一个响应会被重复使用
+type rule struct {
+	match   Matcher
+	replies []Reply
+	next    int
+}
+
+// reply 获取下一个响应
+func (r *rule) reply() Reply {
+	reply := r.replies[r.next]
+	if r.next < len(r.replies)-1 {
+		r.next++
+	}
+	return reply
+}
+
+// Server 测试服务
+type Server struct {
+	*httptest.Server
+
+	// Token 返回的token, 调用RevokeTokens后会在末尾添加序号
+	Token string
+	// TokenExpiresIn 返回的token有效期, 单位为秒, 为0时不返回
+	TokenExpiresIn int
+	// Models 返回的模型列表
+	Models []fengchao.Model
+
+	rules        []*rule
+	fallback     Reply
+	tokenReplies *rule
+	requests     []*fengchao.ChatCompletion
+	headers      []http.Header
+	tokenKeys    []string
+	revoked      int
+	mu           sync.Mutex
+}
+
+// NewServer 创建并启动测试服务, 使用结束后需要调用Close
+func NewServer() *Server {
+	s := &Server{
+		Token:    DefaultToken,
+		Models:   DefaultModels,
+		fallback: Reply{Content: "ok"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.handleToken)
+	mux.HandleFunc("/models/", s.handleModels)
+	mux.HandleFunc("/chat/", s.handleChat)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Client 创建连接到测试服务的客户端
+func (s *Server) Client(options ...fengchao.Option[fengchao.FengChao]) *fengchao.FengChao {
+	return fengchao.NewFengChao("fengchaotest-key", "fengchaotest-secret", s.URL, options...)
+}
+
+// On 为匹配的请求编排响应, 先添加的规则优先匹配
+func (s *Server) On(match Matcher, replies ...Reply) *Server {
+	if len(replies) == 0 {
+		panic("fengchaotest: at least one reply is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, &rule{match: match, replies: replies})
+	return s
+}
+
+// OnModel 为指定模型的请求编排响应
+func (s *Server) OnModel(model string, replies ...Reply) *Server {
+	return s.On(func(req *fengchao.ChatCompletion) bool {
+		return req.Model == model
+	}, replies...)
+}
+
+// OnQuery 为问题中包含指定内容的请求编排响应
+func (s *Server) OnQuery(contains string, replies ...Reply) *Server {
+	return s.On(func(req *fengchao.ChatCompletion) bool {
+		return strings.Contains(req.Query, contains)
+	}, replies...)
+}
+
+// SetDefault 设置没有匹配规则时的响应
+func (s *Server) SetDefault(reply Reply) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = reply
+	return s
+}
+
+// OnToken 编排获取token的响应, 只使用Status、Msg、HTTPStatus、Header和Latency
+func (s *Server) OnToken(replies ...Reply) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenReplies = &rule{replies: replies}
+	return s
+}
+
+// Requests 获取收到的对话请求
+func (s *Server) Requests() []*fengchao.ChatCompletion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*fengchao.ChatCompletion(nil), s.requests...)
+}
+
+// LastRequest 获取最后一个对话请求, 没有请求时为空
+func (s *Server) LastRequest() *fengchao.ChatCompletion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+// Headers 获取对话请求的请求头, 与Requests一一对应
+func (s *Server) Headers() []http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]http.Header(nil), s.headers...)
+}
+
+// RevokeTokens 作废已经签发的token, 之后的对话请求使用旧token时返回401
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked++
+}
+
+// token 当前有效的token, 需要持有锁
+func (s *Server) token() string {
+	if s.revoked == 0 {
+		return s.Token
+	}
+	return fmt.Sprintf("%s-%d", s.Token, s.revoked)
+}
+
+// TokenRequests 获取token请求的次数
+func (s *Server) TokenRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tokenKeys)
+}
+
+// TokenKeys 获取每次token请求使用的api_key
+func (s *Server) TokenKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tokenKeys...)
+}
+
+// handleToken 获取token
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.tokenKeys = append(s.tokenKeys, r.URL.Query().Get("api_key"))
+	reply := Reply{}
+	if s.tokenReplies != nil {
+		reply = s.tokenReplies.reply()
+	}
+	token := s.token()
+	expiresIn := s.TokenExpiresIn
+	s.mu.Unlock()
+
+	if !prepare(w, r, reply) {
+		return
+	}
+	if r.URL.Query().Get("api_key") == "" || r.URL.Query().Get("secret_key") == "" {
+		writeJSON(w, http.StatusOK, map[string]any{"status": http.StatusUnauthorized, "msg": "api_key and secret_key are required"})
+		return
+	}
+	res := map[string]any{"status": statusOf(reply), "msg": reply.Msg, "token": token}
+	if expiresIn > 0 {
+		res["expires_in"] = expiresIn
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleModels 获取模型列表
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	models := s.Models
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"data": models})
+}
+
+// handleChat 对话
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"detail": "method not allowed"})
+		return
+	}
+	req := &fengchao.ChatCompletion{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"detail": fmt.Sprintf("invalid request body: %v", err)})
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.headers = append(s.headers, r.Header.Clone())
+	reply := s.fallback
+	for _, rule := range s.rules {
+		if rule.match(req) {
+			reply = rule.reply()
+			break
+		}
+	}
+	token := s.token()
+	s.mu.Unlock()
+
+	if r.Header.Get("Authorization") != token {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": "invalid token"})
+		return
+	}
+	if !prepare(w, r, reply) {
+		return
+	}
+	if req.Mode == fengchao.StreamMode {
+		s.stream(w, r, req, reply)
+		return
+	}
+	writeJSON(w, http.StatusOK, result(req, reply, reply.Content, true))
+}
+
+// stream 输出流式响应
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, req *fengchao.ChatCompletion, reply Reply) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	send := func(event string, data any) {
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	chunks := reply.Chunks
+	if len(chunks) == 0 {
+		chunks = []string{reply.Content}
+	}
+	send(fengchao.StreamStartEvent, map[string]any{"request_id": req.RequestID, "status": http.StatusOK})
+	for i, chunk := range chunks {
+		if i > 0 && reply.ChunkDelay > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(reply.ChunkDelay):
+			}
+		}
+		send(fengchao.StreamAddEvent, result(req, Reply{}, chunk, false))
+	}
+	for i, call := range reply.ToolCalls {
+		half := len(call.Function.Arguments) / 2
+		deltas := []fengchao.ToolCall{
+			{Index: i, ID: call.ID, Type: fengchao.ToolTypeFunction, Function: fengchao.FunctionCall{Name: call.Function.Name}},
+			{Index: i, Function: fengchao.FunctionCall{Arguments: call.Function.Arguments[:half]}},
+			{Index: i, Function: fengchao.FunctionCall{Arguments: call.Function.Arguments[half:]}},
+		}
+		for _, delta := range deltas {
+			send(fengchao.StreamAddEvent, result(req, Reply{ToolCalls: []fengchao.ToolCall{delta}}, "", false))
+		}
+	}
+	if reply.StreamError {
+		send(fengchao.StreamErrorEvent, map[string]any{"request_id": req.RequestID, "status": statusOf(reply), "msg": reply.Msg})
+		return
+	}
+	send(fengchao.StreamFinishEvent, result(req, reply, "", true))
+}
+
+// prepare 处理延迟、响应头和HTTP错误, 返回是否继续输出响应
+func prepare(w http.ResponseWriter, r *http.Request, reply Reply) bool {
+	if reply.Latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return false
+		case <-time.After(reply.Latency):
+		}
+	}
+	for k, v := range reply.Header {
+		w.Header().Set(k, v)
+	}
+	if reply.HTTPStatus != 0 && reply.HTTPStatus != http.StatusOK {
+		writeJSON(w, reply.HTTPStatus, map[string]any{"detail": reply.Msg})
+		return false
+	}
+	return true
+}
+
+// statusOf 获取业务状态码
+func statusOf(reply Reply) int {
+	if reply.Status == 0 {
+		return http.StatusOK
+	}
+	return reply.Status
+}
+
+// result 生成与ChatCompletionResult一致的响应
+func result(req *fengchao.ChatCompletion, reply Reply, content string, final bool) map[string]any {
+	res := map[string]any{
+		"request_id": req.RequestID,
+		"object":     "chat.completion",
+		"created":    time.Now().Format(time.DateTime),
+		"status":     statusOf(reply),
+		"msg":        reply.Msg,
+	}
+	contents := []string{content}
+	if req.N > 1 && final && req.Mode != fengchao.StreamMode {
+		contents = make([]string, req.N)
+		for i := range contents {
+			contents[i] = content
+			if i < len(reply.Choices) {
+				contents[i] = reply.Choices[i]
+			}
+		}
+	}
+	choices := make([]map[string]any, 0, len(contents))
+	for i, content := range contents {
+		message := map[string]any{"role": fengchao.RoleAssistant, "content": content}
+		if len(reply.ToolCalls) > 0 && (i == 0 && !final || final && req.Mode != fengchao.StreamMode) {
+			message["tool_calls"] = reply.ToolCalls
+		}
+		choice := map[string]any{
+			"index":   i,
+			"role":    fengchao.RoleAssistant,
+			"message": message,
+		}
+		if final {
+			finishReason := reply.FinishReason
+			switch {
+			case finishReason != "":
+			case len(reply.ToolCalls) > 0:
+				finishReason = fengchao.FinishReasonToolCalls
+			default:
+				finishReason = "stop"
+			}
+			choice["finish_reason"] = finishReason
+		}
+		choices = append(choices, choice)
+	}
+	if final {
+		res["usage"] = map[string]any{
+			"prompt_tokens":     reply.PromptTokens,
+			"completion_tokens": reply.CompletionTokens,
+			"total_tokens":      reply.PromptTokens + reply.CompletionTokens,
+		}
+	}
+	res["choices"] = choices
+	return res
+}
+
+// writeJSON 输出json
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	// 需要在WriteHeader之前设置响应头
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,183 @@
+package fengchaotest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+)
+
+func TestServerChatCompletion(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.OnModel("glm-4", Reply{Content: "glm", PromptTokens: 3, CompletionTokens: 1})
+	server.OnQuery("天气", Reply{Content: "晴"}, Reply{Content: "雨"})
+
+	client := server.Client()
+	ctx := context.Background()
+
+	result, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好"), fengchao.WithModel("glm-4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "glm" || result.Usage.TotalTokens != 4 {
+		t.Fatalf("unexpected result: %s %+v", result.String(), result.Usage)
+	}
+
+	for _, want := range []string{"晴", "雨", "雨"} {
+		result, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "今天天气"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.String() != want {
+			t.Fatalf("got %q, want %q", result.String(), want)
+		}
+	}
+
+	result, err = client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "其他"), fengchao.WithSystem("系统"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "ok" {
+		t.Fatalf("got %q, want default reply", result.String())
+	}
+
+	requests := server.Requests()
+	if len(requests) != 5 {
+		t.Fatalf("got %d requests, want 5", len(requests))
+	}
+	last := server.LastRequest()
+	if last.Query != "其他" || last.System != "系统" || last.Mode != fengchao.InvokeMode && last.Mode != "" {
+		t.Fatalf("unexpected last request: %+v", last)
+	}
+	if server.TokenRequests() != 1 {
+		t.Fatalf("got %d token requests, want 1", server.TokenRequests())
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.OnQuery("敏感", Reply{Status: 400, Msg: "sensitive content"})
+	server.OnQuery("限流", Reply{HTTPStatus: http.StatusTooManyRequests, Msg: "too many requests", Header: map[string]string{"Retry-After": "2"}})
+	server.OnQuery("超时", Reply{Latency: 2 * time.Second})
+
+	client := server.Client(fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}))
+	ctx := context.Background()
+
+	_, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "敏感"))
+	if !errors.Is(err, fengchao.ErrSensitiveContent) {
+		t.Fatalf("got %v, want ErrSensitiveContent", err)
+	}
+
+	_, err = client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "限流"))
+	var apiErr *fengchao.APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, fengchao.ErrRateLimited) || apiErr.RetryAfter != 2*time.Second {
+		t.Fatalf("got %v, want rate limited APIError with Retry-After", err)
+	}
+
+	_, err = client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "超时"), fengchao.WithTimeout(1))
+	if !errors.Is(err, fengchao.ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+}
+
+func TestServerErrorContentType(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.OnQuery("限流", Reply{HTTPStatus: http.StatusTooManyRequests, Msg: "too many requests"})
+
+	tests := []struct {
+		method, body, token string
+		status              int
+	}{
+		{http.MethodGet, "", DefaultToken, http.StatusMethodNotAllowed},
+		{http.MethodPost, "{", DefaultToken, http.StatusUnprocessableEntity},
+		{http.MethodPost, `{"query": "你好"}`, "invalid", http.StatusUnauthorized},
+		{http.MethodPost, `{"query": "限流"}`, DefaultToken, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, server.URL+"/chat/", strings.NewReader(tt.body))
+		req.Header.Set("Authorization", tt.token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: got %d %q, want %d application/json", tt.method, tt.body, resp.StatusCode, resp.Header.Get("Content-Type"), tt.status)
+		}
+	}
+}
+
+func TestServerTokenError(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.OnToken(Reply{Status: 401, Msg: "invalid api key"}, Reply{})
+
+	client := server.Client(fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}))
+	_, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"))
+	if !errors.Is(err, fengchao.ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+	if server.TokenRequests() != 2 {
+		t.Fatalf("got %d token requests, want 2", server.TokenRequests())
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.OnQuery("慢", Reply{Chunks: []string{"一", "二", "三"}, ChunkDelay: 50 * time.Millisecond})
+	server.OnQuery("错误", Reply{Chunks: []string{"一"}, StreamError: true, Status: 500, Msg: "model error"})
+
+	client := server.Client(fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}))
+	ctx := context.Background()
+
+	start := time.Now()
+	reader, err := client.ChatCompletionStream(ctx, fengchao.NewMessage(fengchao.RoleUser, "慢一点"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content strings.Builder
+	for msg := range reader.Stream() {
+		content.WriteString(msg.String())
+	}
+	if content.String() != "一二三" {
+		t.Fatalf("got %q, want 一二三", content.String())
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("stream finished in %v, want chunk delays", elapsed)
+	}
+	if server.LastRequest().Mode != fengchao.StreamMode {
+		t.Fatalf("got mode %q, want stream", server.LastRequest().Mode)
+	}
+
+	reader, err = client.ChatCompletionStream(ctx, fengchao.NewMessage(fengchao.RoleUser, "错误"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamErr error
+	for {
+		_, finished, err := reader.Read()
+		if err != nil {
+			streamErr = err
+			break
+		}
+		if finished {
+			break
+		}
+	}
+	reader.Close()
+	var apiErr *fengchao.APIError
+	if !errors.As(streamErr, &apiErr) || apiErr.Msg != "model error" {
+		t.Fatalf("got %v, want APIError from error event", streamErr)
+	}
+}
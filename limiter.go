@@ -0,0 +1,178 @@
+package fengchaogo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimit 限流配置, 为0时表示不限制
+type RateLimit struct {
+	// RequestsPerMinute 每分钟请求数
+	RequestsPerMinute int `json:"requests_per_minute" yaml:"requests_per_minute" toml:"requests_per_minute"`
+	// TokensPerMinute 每分钟token数
+	TokensPerMinute int `json:"tokens_per_minute" yaml:"tokens_per_minute" toml:"tokens_per_minute"`
+}
+
+// RateLimiter 客户端限流器, 按照模型分别限制每分钟的请求数和token数
+// 多个客户端可以共享同一个限流器
+type RateLimiter struct {
+	// defaultLimit 没有单独配置的模型使用的限流配置
+	defaultLimit RateLimit
+	// limits 模型的限流配置
+	limits map[string]RateLimit
+
+	buckets map[string]*rateBucket
+	mu      sync.Mutex
+}
+
+// NewRateLimiter 创建限流器, limits为每个模型单独的配置
+func NewRateLimiter(defaultLimit RateLimit, limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{
+		defaultLimit: defaultLimit,
+		limits:       limits,
+		buckets:      make(map[string]*rateBucket),
+	}
+}
+
+// rateBucket 单个模型的令牌桶
+type rateBucket struct {
+	limit     RateLimit
+	requests  float64
+	tokens    float64
+	updatedAt time.Time
+}
+
+// refill 按照流逝的时间补充令牌
+func (b *rateBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updatedAt).Minutes()
+	b.updatedAt = now
+	if b.limit.RequestsPerMinute > 0 {
+		b.requests = min(float64(b.limit.RequestsPerMinute), b.requests+elapsed*float64(b.limit.RequestsPerMinute))
+	}
+	if b.limit.TokensPerMinute > 0 {
+		b.tokens = min(float64(b.limit.TokensPerMinute), b.tokens+elapsed*float64(b.limit.TokensPerMinute))
+	}
+}
+
+// reserve 尝试扣除令牌, 不足时返回需要等待的时间
+func (b *rateBucket) reserve(tokens int) time.Duration {
+	var wait time.Duration
+	if b.limit.RequestsPerMinute > 0 && b.requests < 1 {
+		wait = max(wait, time.Duration((1-b.requests)/float64(b.limit.RequestsPerMinute)*float64(time.Minute)))
+	}
+	if b.limit.TokensPerMinute > 0 {
+		// 超过桶容量的请求在桶满时放行, 避免永远无法发送
+		need := min(float64(tokens), float64(b.limit.TokensPerMinute))
+		if b.tokens < need {
+			wait = max(wait, time.Duration((need-b.tokens)/float64(b.limit.TokensPerMinute)*float64(time.Minute)))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	b.requests--
+	b.tokens -= float64(tokens)
+	return 0
+}
+
+// bucket 获取模型的令牌桶
+func (r *RateLimiter) bucket(model string) *rateBucket {
+	b, ok := r.buckets[model]
+	if !ok {
+		limit, ok := r.limits[model]
+		if !ok {
+			limit = r.defaultLimit
+		}
+		b = &rateBucket{
+			limit:     limit,
+			requests:  float64(limit.RequestsPerMinute),
+			tokens:    float64(limit.TokensPerMinute),
+			updatedAt: time.Now(),
+		}
+		r.buckets[model] = b
+	}
+	return b
+}
+
+// Wait 阻塞直到模型有足够的请求数和token数, ctx被取消时返回错误
+func (r *RateLimiter) Wait(ctx context.Context, model string, tokens int) error {
+	for {
+		r.mu.Lock()
+		b := r.bucket(model)
+		b.refill(time.Now())
+		wait := b.reserve(tokens)
+		r.mu.Unlock()
+
+		if wait == 0 {
+			return nil
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Adjust 使用实际消耗的token数修正预估的token数
+func (r *RateLimiter) Adjust(model string, estimated int, actual int) {
+	if actual <= 0 || actual == estimated {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.bucket(model)
+	if b.limit.TokensPerMinute > 0 {
+		b.tokens = min(float64(b.limit.TokensPerMinute), b.tokens+float64(estimated-actual))
+	}
+}
+
+// refund 归还没有消耗的token, 例如没有返回数据就失败的请求
+func (r *RateLimiter) refund(model string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.bucket(model)
+	if b.limit.TokensPerMinute > 0 {
+		b.tokens = min(float64(b.limit.TokensPerMinute), b.tokens+float64(tokens))
+	}
+}
+
+// estimateRequestTokens 估算一次请求消耗的token数: 渲染后的消息加上最大生成长度
+func estimateRequestTokens(params *ChatCompletion) int {
+	return EstimateRequest(params) + params.MaxTokens
+}
+
+// waitRateLimit 等待限流器放行, 返回预估的token数
+func (f *FengChao) waitRateLimit(ctx context.Context, params *ChatCompletion) (int, error) {
+	if f.limiter == nil {
+		return 0, nil
+	}
+	estimated := estimateRequestTokens(params)
+	start := time.Now()
+	if err := f.limiter.Wait(ctx, params.Model, estimated); err != nil {
+		return 0, fmt.Errorf("wait for rate limiter: %w", err)
+	}
+	if waited := time.Since(start); waited > time.Millisecond {
+		f.logger.Debug("fengchao rate limited", "request_id", params.RequestID, "model", params.Model, "tokens", estimated, "waited", waited)
+	}
+	return estimated, nil
+}
+
+// adjustRateLimit 使用实际的token用量修正限流器
+func (f *FengChao) adjustRateLimit(params *ChatCompletion, estimated int, totalTokens int) {
+	if f.limiter == nil {
+		return
+	}
+	f.limiter.Adjust(params.Model, estimated, totalTokens)
+}
+
+// refundRateLimit 归还失败的请求预估的token数
+func (f *FengChao) refundRateLimit(params *ChatCompletion, estimated int) {
+	if f.limiter == nil {
+		return
+	}
+	f.limiter.refund(params.Model, estimated)
+}
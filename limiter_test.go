@@ -0,0 +1,196 @@
+package fengchaogo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{RequestsPerMinute: 1}, map[string]RateLimit{
+		"glm-4": {RequestsPerMinute: 60000},
+	})
+
+	if err := limiter.Wait(context.Background(), "gpt-4o", 0); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "gpt-4o", 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 模型单独的配置不受默认配置的影响
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background(), "glm-4", 0); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+}
+
+func TestRateLimiter_Adjust(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{TokensPerMinute: 1000}, nil)
+
+	if err := limiter.Wait(context.Background(), "gpt-4o", 1000); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	// 实际只消耗了100个token, 剩余的额度应该被归还
+	limiter.Adjust("gpt-4o", 1000, 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "gpt-4o", 800); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+}
+
+// usageServer 返回固定token用量的测试服务, 记录对话请求的次数
+func usageServer(t *testing.T, totalTokens int) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"status": 200, "token": "token"})
+			return
+		}
+		var params ChatCompletion
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			t.Errorf("decode request body error = %v", err)
+		}
+		requests.Add(1)
+		usage := map[string]any{"total_tokens": totalTokens}
+		if params.Mode == StreamMode {
+			data, _ := json.Marshal(map[string]any{"status": 200, "usage": usage})
+			fmt.Fprintf(w, "event: add\ndata: {\"status\":200,\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":\"hi\"}}]}\n\nevent: stop\ndata: %s\n\n", data)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":  200,
+			"choices": []map[string]any{{"message": map[string]any{"role": RoleAssistant, "content": "hi"}}},
+			"usage":   usage,
+		})
+	}))
+	return server, &requests
+}
+
+// drainRequests 用完模型每分钟的请求数
+func drainRequests(t *testing.T, limiter *RateLimiter, model string, n int) {
+	t.Helper()
+	for range n {
+		if err := limiter.Wait(context.Background(), model, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readAll 读取数据流直到结束
+func readAll(t *testing.T, reader *JsonStreamReader[ChatCompletionResult]) {
+	t.Helper()
+	defer reader.Close()
+	for {
+		_, finished, err := reader.Read()
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if finished {
+			return
+		}
+	}
+}
+
+func TestFengChao_RateLimiterBlocks(t *testing.T) {
+	server, requests := usageServer(t, 100)
+	defer server.Close()
+
+	// 每100毫秒补充一个请求
+	limiter := NewRateLimiter(RateLimit{RequestsPerMinute: 600}, nil)
+	client := NewFengChao("key", "secret", server.URL, WithRateLimiter(limiter))
+	ctx := context.Background()
+
+	drainRequests(t, limiter, "glm-4", 600)
+	start := time.Now()
+	if _, err := client.ChatCompletion(ctx, NewUserMessage("hello"), WithModel("glm-4")); err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("ChatCompletion() took %s, want blocked by limiter", elapsed)
+	}
+
+	drainRequests(t, limiter, "glm-4", 1)
+	start = time.Now()
+	reader, err := client.ChatCompletionStream(ctx, NewUserMessage("hello"), WithModel("glm-4"))
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	readAll(t, reader)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("ChatCompletionStream() took %s, want blocked by limiter", elapsed)
+	}
+
+	// 批量请求中的每个请求都需要等待
+	drainRequests(t, limiter, "glm-4", 1)
+	builder := NewBatchChatCompletionBuilder()
+	for range 2 {
+		if _, err := builder.Add(NewUserMessage("hello"), WithModel("glm-4")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start = time.Now()
+	if _, errs, ok := client.BatchChatCompletion(ctx, builder); !ok {
+		t.Fatalf("BatchChatCompletion() errors = %v", errs)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("BatchChatCompletion() took %s, want blocked by limiter", elapsed)
+	}
+
+	// 等待时ctx取消, 不发送请求
+	sent := requests.Load()
+	drainRequests(t, limiter, "glm-4", 1)
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.ChatCompletion(timeout, NewUserMessage("hello"), WithModel("glm-4")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ChatCompletion() error = %v, want context.DeadlineExceeded", err)
+	}
+	if got := requests.Load(); got != sent {
+		t.Errorf("requests = %d, want %d", got, sent)
+	}
+}
+
+func TestFengChao_RateLimiterAdjust(t *testing.T) {
+	server, _ := usageServer(t, 100)
+	defer server.Close()
+
+	limiter := NewRateLimiter(RateLimit{TokensPerMinute: 100000}, nil)
+	client := NewFengChao("key", "secret", server.URL, WithRateLimiter(limiter))
+	ctx := context.Background()
+	tokens := func(model string) float64 {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return limiter.buckets[model].tokens
+	}
+
+	// 预估的token数包括MaxTokens, 响应返回后按照实际用量100修正
+	if _, err := client.ChatCompletion(ctx, NewUserMessage("hello"), WithModel("glm-4")); err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	if got := tokens("glm-4"); got < 99899 || got > 99900 {
+		t.Errorf("tokens after ChatCompletion = %v, want 99900", got)
+	}
+
+	reader, err := client.ChatCompletionStream(ctx, NewUserMessage("hello"), WithModel("gpt-4o"))
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	readAll(t, reader)
+	if got := tokens("gpt-4o"); got < 99899 || got > 99900 {
+		t.Errorf("tokens after ChatCompletionStream = %v, want 99900", got)
+	}
+}
@@ -0,0 +1,105 @@
+package fengchaogo
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// Logger 结构化日志接口, args为交替出现的key/value, 与slog的约定一致
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// NewSlogLogger 使用slog创建日志, logger为空时使用slog.Default()
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &slogLogger{logger: logger.With("sdk", DefaultUserAgent)}
+}
+
+// slogLogger slog适配器
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogLogger) Debug(msg string, args ...any) { l.logger.Debug(msg, args...) }
+func (l *slogLogger) Info(msg string, args ...any)  { l.logger.Info(msg, args...) }
+func (l *slogLogger) Warn(msg string, args ...any)  { l.logger.Warn(msg, args...) }
+func (l *slogLogger) Error(msg string, args ...any) { l.logger.Error(msg, args...) }
+
+// nopLogger 不输出任何日志, 作为默认日志
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// redactedPlaceholder 脱敏后的占位符
+const redactedPlaceholder = "******"
+
+// redact 对密钥、token等敏感信息进行脱敏, 只保留前4位用于排查
+func redact(secret string) string {
+	if len(secret) <= 8 {
+		return redactedPlaceholder
+	}
+	return secret[:4] + redactedPlaceholder
+}
+
+// redactedURLError 去掉请求参数后的错误, 保留原始错误的分类
+type redactedURLError struct {
+	msg string
+	err *url.Error
+}
+
+func (e *redactedURLError) Error() string { return e.msg }
+func (e *redactedURLError) Unwrap() error { return e.err }
+
+// redactError 去掉错误中请求地址的参数, 避免获取token时的api_key和secret_key出现在日志、链路追踪和返回的错误中
+func redactError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	redacted := &url.Error{Op: urlErr.Op, URL: redactURL(urlErr.URL), Err: urlErr.Err}
+	if redacted.URL == urlErr.URL {
+		return err
+	}
+	if err == error(urlErr) {
+		return redacted
+	}
+	// 外层的错误信息已经包含了完整的地址, 替换后重新包装
+	return &redactedURLError{msg: strings.ReplaceAll(err.Error(), urlErr.URL, redacted.URL), err: redacted}
+}
+
+// redactURL 去掉地址中的请求参数
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		before, _, _ := strings.Cut(rawURL, "?")
+		return before
+	}
+	u.RawQuery = ""
+	u.ForceQuery = false
+	return u.String()
+}
+
+// redactHeaders 对请求头中的敏感信息进行脱敏
+func redactHeaders(headers map[string][]string) map[string]string {
+	redacted := make(map[string]string, len(headers))
+	for k, v := range headers {
+		value := strings.Join(v, ",")
+		switch strings.ToLower(k) {
+		case "authorization", "cookie", "x-api-key":
+			value = redact(value)
+		}
+		redacted[k] = value
+	}
+	return redacted
+}
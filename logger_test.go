@@ -0,0 +1,104 @@
+package fengchaogo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFengChao_LoggerRedactsSecrets(t *testing.T) {
+	const (
+		apiKey    = "api-key-1234567890"
+		secretKey = "secret-key-1234567890"
+		token     = "token-1234567890"
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			json.NewEncoder(w).Encode(map[string]any{"status": 200, "token": token})
+		case "/chat/":
+			json.NewEncoder(w).Encode(map[string]any{
+				"request_id": "abc",
+				"status":     200,
+				"usage":      map[string]any{"prompt_tokens": 3, "completion_tokens": 5, "total_tokens": 8},
+			})
+		}
+	}))
+	defer server.Close()
+
+	buffer := &bytes.Buffer{}
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(buffer, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	client := NewFengChao(apiKey, secretKey, server.URL, WithLogger(logger), WithDebug(true))
+
+	if _, err := client.ChatCompletion(context.Background(), NewUserMessage("hello"), WithRequestID("abc")); err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+
+	output := buffer.String()
+	for _, secret := range []string{apiKey, secretKey, token} {
+		if strings.Contains(output, secret) {
+			t.Errorf("log output contains secret %q", secret)
+		}
+	}
+	for _, want := range []string{"fengchao token refreshed", "fengchao chat completion response", `"request_id":"abc"`, `"total_tokens":8`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("log output missing %q", want)
+		}
+	}
+}
+
+func TestFengChao_TokenErrorRedactsSecrets(t *testing.T) {
+	const (
+		apiKey    = "api-key-1234567890"
+		secretKey = "secret-key-1234567890"
+	)
+	// 关闭的服务地址, 获取token时返回连接错误
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	buffer := &bytes.Buffer{}
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(buffer, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	client := NewFengChao(apiKey, secretKey, server.URL, WithLogger(logger))
+
+	_, err := client.ChatCompletion(context.Background(), NewUserMessage("hello"))
+	if err == nil {
+		t.Fatal("want token error")
+	}
+	if !strings.Contains(buffer.String(), "fengchao token refresh failed") {
+		t.Fatalf("log output missing token refresh failure: %s", buffer)
+	}
+	for _, secret := range []string{apiKey, secretKey} {
+		if strings.Contains(err.Error(), secret) {
+			t.Errorf("error contains secret %q: %v", secret, err)
+		}
+		if strings.Contains(buffer.String(), secret) {
+			t.Errorf("log output contains secret %q", secret)
+		}
+	}
+	if !strings.Contains(err.Error(), server.URL+"/token") {
+		t.Errorf("error %q should keep the request path", err)
+	}
+}
+
+func Test_redactError(t *testing.T) {
+	urlErr := &url.Error{Op: "Get", URL: "http://fengchao.api/token?api_key=key&secret_key=secret", Err: errors.New("connection refused")}
+	for _, err := range []error{urlErr, fmt.Errorf("get auth token client error: %w", urlErr)} {
+		redacted := redactError(err)
+		if strings.Contains(redacted.Error(), "secret") {
+			t.Errorf("got %q", redacted)
+		}
+		var got *url.Error
+		if !errors.As(redacted, &got) || got.URL != "http://fengchao.api/token" {
+			t.Errorf("got %+v, want *url.Error without query", got)
+		}
+	}
+}
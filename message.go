@@ -7,7 +7,7 @@ import (
 	"text/template"
 )
 
-// 消息的角色, user and assistant and system
+// 消息的角色, user and assistant and system and tool
 const (
 	// RoleUser  用户消息
 	RoleUser = "user"
@@ -15,12 +15,20 @@ const (
 	RoleAssistant = "assistant"
 	// RoleSystem  系统消息
 	RoleSystem = "system"
+	// RoleTool  工具的执行结果消息
+	RoleTool = "tool"
 )
 
 // Message 消息
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls 模型调用的工具, 只在assistant消息中出现
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID 工具执行结果对应的调用ID, 只在tool消息中出现
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// Parts 文本以外的内容片段, 例如图片, 不为空时content序列化为内容片段数组, Content作为第一个文本片段
+	Parts []ContentPart `json:"-"`
 
 	template *template.Template
 	buffer   *bytes.Buffer
@@ -48,7 +56,7 @@ func (m *Message) execute(vairables map[string]interface{}) error {
 
 // checkRole 检查角色
 func (m *Message) checkRole() error {
-	if m.Role != RoleUser && m.Role != RoleAssistant && m.Role != RoleSystem {
+	if m.Role != RoleUser && m.Role != RoleAssistant && m.Role != RoleSystem && m.Role != RoleTool {
 		return fmt.Errorf("message role is invalid")
 	}
 	return nil
@@ -171,3 +171,22 @@ func TestRenderLazyMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestMessage_checkRole(t *testing.T) {
+	for _, role := range []string{RoleUser, RoleAssistant, RoleSystem, RoleTool} {
+		if err := (&Message{Role: role}).checkRole(); err != nil {
+			t.Errorf("role %s: %v", role, err)
+		}
+	}
+	if err := (&Message{Role: "function"}).checkRole(); err == nil {
+		t.Error("want invalid role error")
+	}
+
+	data, err := NewToolMessage("call_1", "25度").Render(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"role":"tool","content":"25度","tool_call_id":"call_1"}`; string(data) != want {
+		t.Fatalf("got %s, want %s", data, want)
+	}
+}
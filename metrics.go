@@ -0,0 +1,101 @@
+package fengchaogo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Metrics 指标收集接口, 客户端会在每次请求时调用, 实现需要保证并发安全
+type Metrics interface {
+	// RequestStarted 请求开始, 用于统计正在进行的请求数
+	RequestStarted(kind RequestKind, model string)
+	// RequestFinished 请求结束, status为请求结果的分类, 例如ok、timeout、429
+	RequestFinished(kind RequestKind, model string, status string, latency time.Duration)
+	// TimeToFirstToken 流式对话收到第一个数据包的时间
+	TimeToFirstToken(model string, latency time.Duration)
+	// TokenUsage 对话消耗的token数
+	TokenUsage(model string, promptTokens int, completionTokens int)
+	// TokenRefreshFailed 刷新token失败
+	TokenRefreshFailed()
+}
+
+// nopMetrics 不收集指标, 作为默认实现
+type nopMetrics struct{}
+
+func (nopMetrics) RequestStarted(RequestKind, string)                         {}
+func (nopMetrics) RequestFinished(RequestKind, string, string, time.Duration) {}
+func (nopMetrics) TimeToFirstToken(string, time.Duration)                     {}
+func (nopMetrics) TokenUsage(string, int, int)                                {}
+func (nopMetrics) TokenRefreshFailed()                                        {}
+
+// 请求结果的分类
+const (
+	MetricStatusOK          = "ok"
+	MetricStatusError       = "error"
+	MetricStatusTimeout     = "timeout"
+	MetricStatusCanceled    = "canceled"
+	MetricStatusCircuitOpen = "circuit_open"
+)
+
+// metricStatus 根据错误获取请求结果的分类, 服务端返回的错误使用状态码
+func metricStatus(err error) string {
+	if err == nil {
+		return MetricStatusOK
+	}
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		if apiErr.HTTPStatus != 0 && apiErr.HTTPStatus != http.StatusOK {
+			return strconv.Itoa(apiErr.HTTPStatus)
+		}
+		return strconv.Itoa(apiErr.Status)
+	case errors.Is(err, ErrCircuitOpen):
+		return MetricStatusCircuitOpen
+	case errors.Is(err, context.DeadlineExceeded):
+		return MetricStatusTimeout
+	case errors.Is(err, context.Canceled):
+		return MetricStatusCanceled
+	}
+	return MetricStatusError
+}
+
+// metricsRecorder 记录一次请求的指标
+type metricsRecorder struct {
+	metrics Metrics
+	kind    RequestKind
+	model   string
+	start   time.Time
+}
+
+// startMetrics 开始记录请求的指标
+func (f *FengChao) startMetrics(kind RequestKind, model string) *metricsRecorder {
+	f.metrics.RequestStarted(kind, model)
+	return &metricsRecorder{metrics: f.metrics, kind: kind, model: model, start: time.Now()}
+}
+
+// finish 请求结束, 记录结果和token用量
+func (r *metricsRecorder) finish(result *ChatCompletionResult, err error) {
+	r.metrics.RequestFinished(r.kind, r.model, metricStatus(err), time.Since(r.start))
+	if result != nil && (result.Usage.PromptTokens > 0 || result.Usage.CompletionTokens > 0) {
+		r.metrics.TokenUsage(r.model, result.Usage.PromptTokens, result.Usage.CompletionTokens)
+	}
+}
+
+// observeStream 记录数据流的首包时间和结果, 在数据流关闭时结束
+func (r *metricsRecorder) observeStream(reader *JsonStreamReader[ChatCompletionResult]) {
+	first := true
+	var last *ChatCompletionResult
+	reader.OnMessage(func(msg *ChatCompletionResult) {
+		if first {
+			first = false
+			r.metrics.TimeToFirstToken(r.model, time.Since(r.start))
+		}
+		last = msg
+	})
+	reader.OnClose(func(err error) {
+		r.finish(last, err)
+	})
+}
@@ -0,0 +1,223 @@
+package fengchaogo
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets 默认的耗时分布区间(秒)
+var DefaultLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120}
+
+// PrometheusMetrics 以Prometheus文本格式暴露指标的Metrics实现, 可以直接作为http.Handler挂载到/metrics
+type PrometheusMetrics struct {
+	namespace string
+	buckets   []float64
+
+	requests         map[string]float64
+	inFlight         map[string]float64
+	latency          map[string]*histogram
+	timeToFirstToken map[string]*histogram
+	promptTokens     map[string]float64
+	completionTokens map[string]float64
+	refreshFailures  float64
+
+	mu sync.Mutex
+}
+
+var _ Metrics = (*PrometheusMetrics)(nil)
+var _ http.Handler = (*PrometheusMetrics)(nil)
+
+// NewPrometheusMetrics 创建Prometheus指标, namespace为指标名称的前缀, 为空时使用fengchao
+// buckets为耗时分布区间(秒), 为空时使用DefaultLatencyBuckets
+func NewPrometheusMetrics(namespace string, buckets []float64) *PrometheusMetrics {
+	if namespace == "" {
+		namespace = "fengchao"
+	}
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &PrometheusMetrics{
+		namespace:        namespace,
+		buckets:          buckets,
+		requests:         make(map[string]float64),
+		inFlight:         make(map[string]float64),
+		latency:          make(map[string]*histogram),
+		timeToFirstToken: make(map[string]*histogram),
+		promptTokens:     make(map[string]float64),
+		completionTokens: make(map[string]float64),
+	}
+}
+
+// histogram 直方图
+type histogram struct {
+	counts []float64
+	sum    float64
+	count  float64
+}
+
+// observe 记录一个值
+func (h *histogram) observe(buckets []float64, value float64) {
+	for i, bound := range buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// observe 在直方图中记录一个值
+func (m *PrometheusMetrics) observe(histograms map[string]*histogram, labels string, value float64) {
+	h, ok := histograms[labels]
+	if !ok {
+		h = &histogram{counts: make([]float64, len(m.buckets))}
+		histograms[labels] = h
+	}
+	h.observe(m.buckets, value)
+}
+
+// labels 生成标签字符串, 参数为交替出现的name/value
+func labels(pairs ...string) string {
+	builder := strings.Builder{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(pairs[i])
+		builder.WriteString(`="`)
+		builder.WriteString(escapeLabelValue(pairs[i+1]))
+		builder.WriteByte('"')
+	}
+	return builder.String()
+}
+
+// escapeLabelValue 转义标签值
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// RequestStarted 实现Metrics
+func (m *PrometheusMetrics) RequestStarted(kind RequestKind, model string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[labels("kind", string(kind), "model", model)]++
+}
+
+// RequestFinished 实现Metrics
+func (m *PrometheusMetrics) RequestFinished(kind RequestKind, model string, status string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[labels("kind", string(kind), "model", model)]--
+	m.requests[labels("kind", string(kind), "model", model, "status", status)]++
+	m.observe(m.latency, labels("kind", string(kind), "model", model), latency.Seconds())
+}
+
+// TimeToFirstToken 实现Metrics
+func (m *PrometheusMetrics) TimeToFirstToken(model string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observe(m.timeToFirstToken, labels("model", model), latency.Seconds())
+}
+
+// TokenUsage 实现Metrics
+func (m *PrometheusMetrics) TokenUsage(model string, promptTokens int, completionTokens int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.promptTokens[labels("model", model)] += float64(promptTokens)
+	m.completionTokens[labels("model", model)] += float64(completionTokens)
+}
+
+// TokenRefreshFailed 实现Metrics
+func (m *PrometheusMetrics) TokenRefreshFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refreshFailures++
+}
+
+// ServeHTTP 以Prometheus文本格式输出指标
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo 以Prometheus文本格式写入指标
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	builder := &strings.Builder{}
+	m.writeSamples(builder, "requests_total", "counter", "Total number of requests by kind, model and status.", m.requests)
+	m.writeSamples(builder, "requests_in_flight", "gauge", "Number of requests in flight by kind and model.", m.inFlight)
+	m.writeHistograms(builder, "request_duration_seconds", "Request latency in seconds by kind and model.", m.latency)
+	m.writeHistograms(builder, "stream_time_to_first_token_seconds", "Time to the first chunk of streams in seconds by model.", m.timeToFirstToken)
+	m.writeSamples(builder, "prompt_tokens_total", "counter", "Total number of prompt tokens by model.", m.promptTokens)
+	m.writeSamples(builder, "completion_tokens_total", "counter", "Total number of completion tokens by model.", m.completionTokens)
+	m.writeSamples(builder, "token_refresh_failures_total", "counter", "Total number of failed auth token refreshes.", map[string]float64{"": m.refreshFailures})
+
+	n, err := io.WriteString(w, builder.String())
+	return int64(n), err
+}
+
+// writeHeader 写入指标的说明和类型
+func (m *PrometheusMetrics) writeHeader(builder *strings.Builder, name string, kind string, help string) string {
+	name = m.namespace + "_" + name
+	fmt.Fprintf(builder, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	return name
+}
+
+// writeSamples 写入计数器和仪表盘
+func (m *PrometheusMetrics) writeSamples(builder *strings.Builder, name string, kind string, help string, samples map[string]float64) {
+	name = m.writeHeader(builder, name, kind, help)
+	for _, labels := range sortedKeys(samples) {
+		fmt.Fprintf(builder, "%s%s %s\n", name, wrapLabels(labels), formatFloat(samples[labels]))
+	}
+}
+
+// writeHistograms 写入直方图
+func (m *PrometheusMetrics) writeHistograms(builder *strings.Builder, name string, help string, histograms map[string]*histogram) {
+	name = m.writeHeader(builder, name, "histogram", help)
+	for _, labels := range sortedKeys(histograms) {
+		h := histograms[labels]
+		prefix := labels
+		if prefix != "" {
+			prefix += ","
+		}
+		for i, bound := range m.buckets {
+			fmt.Fprintf(builder, "%s_bucket{%sle=\"%s\"} %s\n", name, prefix, formatFloat(bound), formatFloat(h.counts[i]))
+		}
+		fmt.Fprintf(builder, "%s_bucket{%sle=\"+Inf\"} %s\n", name, prefix, formatFloat(h.count))
+		fmt.Fprintf(builder, "%s_sum%s %s\n", name, wrapLabels(labels), formatFloat(h.sum))
+		fmt.Fprintf(builder, "%s_count%s %s\n", name, wrapLabels(labels), formatFloat(h.count))
+	}
+}
+
+// wrapLabels 为标签添加括号
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// formatFloat 格式化数值
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedKeys 排序后的key, 保证输出稳定
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
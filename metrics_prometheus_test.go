@@ -0,0 +1,55 @@
+package fengchaogo
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics_ServeHTTP(t *testing.T) {
+	server := newTracingTestServer(t, false)
+	defer server.Close()
+
+	metrics := NewPrometheusMetrics("", []float64{1, 5})
+	client := NewFengChao("key", "secret", server.URL, WithMetrics(metrics))
+
+	if _, err := client.ChatCompletion(context.Background(), NewUserMessage("hello"), WithModel("glm-4")); err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	reader, err := client.ChatCompletionStream(context.Background(), NewUserMessage("hello"), WithModel("glm-4"))
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	for range reader.Stream() {
+	}
+	metrics.RequestFinished(RequestInvoke, `gpt"4o`, "429", 2*time.Second)
+	metrics.TokenRefreshFailed()
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	output := string(body)
+
+	for _, want := range []string{
+		"# TYPE fengchao_requests_total counter",
+		`fengchao_requests_total{kind="invoke",model="glm-4",status="ok"} 1`,
+		`fengchao_requests_total{kind="stream",model="glm-4",status="ok"} 1`,
+		`fengchao_requests_total{kind="token",model="",status="ok"} 1`,
+		`fengchao_requests_total{kind="invoke",model="gpt\"4o",status="429"} 1`,
+		`fengchao_requests_in_flight{kind="invoke",model="glm-4"} 0`,
+		`fengchao_request_duration_seconds_bucket{kind="invoke",model="glm-4",le="1"} 1`,
+		`fengchao_request_duration_seconds_bucket{kind="invoke",model="gpt\"4o",le="1"} 0`,
+		`fengchao_request_duration_seconds_bucket{kind="invoke",model="gpt\"4o",le="+Inf"} 1`,
+		`fengchao_stream_time_to_first_token_seconds_count{model="glm-4"} 1`,
+		`fengchao_prompt_tokens_total{model="glm-4"} 6`,
+		`fengchao_completion_tokens_total{model="glm-4"} 10`,
+		"fengchao_token_refresh_failures_total 1",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("metrics output missing %q\n%s", want, output)
+		}
+	}
+}
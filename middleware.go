@@ -0,0 +1,147 @@
+package fengchaogo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RequestKind 请求类型
+type RequestKind string
+
+const (
+	// RequestToken 获取token
+	RequestToken RequestKind = "token"
+	// RequestModels 获取模型列表
+	RequestModels RequestKind = "models"
+	// RequestInvoke 对话(ChatCompletion)
+	RequestInvoke RequestKind = "invoke"
+	// RequestQuick 预定义Prompt的快速生成(QuickCompletion)
+	RequestQuick RequestKind = "quick"
+	// RequestStream 流式对话(ChatCompletionStream)
+	RequestStream RequestKind = "stream"
+)
+
+// IsChat 是否为对话请求
+func (k RequestKind) IsChat() bool {
+	return k == RequestInvoke || k == RequestQuick || k == RequestStream
+}
+
+// Request 经过中间件处理的请求
+type Request struct {
+	// Kind 请求类型
+	Kind RequestKind
+	// Method 请求方法
+	Method string
+	// Endpoint 服务地址, 为空时使用BaseUrl
+	Endpoint string
+	// Path 请求路径
+	Path string
+	// Header 请求头
+	Header http.Header
+	// Query 请求参数
+	Query url.Values
+	// Params 对话请求的参数, 获取token和模型列表时为空
+	Params *ChatCompletion
+}
+
+// Response 经过中间件处理的响应
+type Response struct {
+	// StatusCode HTTP状态码
+	StatusCode int
+	// Header 响应头
+	Header http.Header
+	// Result 非流式对话的结果, 只有状态码为200时才有值
+	Result *ChatCompletionResult
+	// Stream 流式对话的数据流, 只有状态码为200时才有值
+	Stream io.ReadCloser
+	// Raw 除数据流之外的原始响应内容
+	Raw []byte
+}
+
+// Handler 处理请求的函数
+type Handler func(ctx context.Context, req *Request) (*Response, error)
+
+// Middleware 中间件, 可以在请求发送前和响应返回后进行处理, 例如添加请求头、记录日志、改写Prompt、过滤响应等
+type Middleware func(next Handler) Handler
+
+// Use 添加中间件, 先添加的中间件在外层, 需要在发送请求之前调用
+func (f *FengChao) Use(middlewares ...Middleware) *FengChao {
+	f.middlewares = append(f.middlewares, middlewares...)
+	return f
+}
+
+// handler 组装中间件链
+func (f *FengChao) handler() Handler {
+	handler := Handler(f.send)
+	for i := len(f.middlewares) - 1; i >= 0; i-- {
+		handler = f.middlewares[i](handler)
+	}
+	return handler
+}
+
+// do 通过中间件链发送请求
+func (f *FengChao) do(ctx context.Context, req *Request) (*Response, error) {
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if req.Query == nil {
+		req.Query = make(url.Values)
+	}
+	f.injectTraceContext(ctx, req)
+	return f.handler()(ctx, req)
+}
+
+// send 发送请求, 是中间件链的最后一环
+func (f *FengChao) send(ctx context.Context, req *Request) (*Response, error) {
+	r := f.client.R().
+		SetContext(ctx).
+		SetHeaderMultiValues(req.Header).
+		SetQueryParamsFromValues(req.Query).
+		SetDoNotParseResponse(true)
+	if req.Params != nil {
+		r.SetBody(req.Params)
+	}
+	if req.Kind.IsChat() {
+		f.logRequest(req)
+	}
+
+	start := time.Now()
+	resp, err := r.Execute(req.Method, req.Endpoint+req.Path)
+	if err != nil {
+		err = redactError(err)
+		if req.Kind.IsChat() {
+			f.logger.Error("fengchao chat completion failed", "request_id", req.Params.RequestID, "model", req.Params.Model, "latency", time.Since(start), "error", err)
+		}
+		return nil, err
+	}
+
+	response := &Response{
+		StatusCode: resp.StatusCode(),
+		Header:     resp.Header(),
+	}
+	body := resp.RawResponse.Body
+	if req.Kind == RequestStream && response.StatusCode == http.StatusOK {
+		response.Stream = body
+	} else {
+		defer body.Close()
+		if response.Raw, err = io.ReadAll(body); err != nil {
+			return nil, fmt.Errorf("read response body error: %w", err)
+		}
+		if req.Kind.IsChat() && response.StatusCode == http.StatusOK {
+			response.Result = &ChatCompletionResult{}
+			if err := json.Unmarshal(response.Raw, response.Result); err != nil {
+				return nil, fmt.Errorf("unmarshal chat completion result error: %w", err)
+			}
+		}
+	}
+
+	if req.Kind.IsChat() {
+		f.logResponse(req, response, time.Since(start))
+	}
+	return response, nil
+}
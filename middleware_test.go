@@ -0,0 +1,64 @@
+package fengchaogo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFengChao_Middleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Tenant"); got != "ijiwei" {
+			t.Errorf("%s X-Tenant = %q, want %q", r.URL.Path, got, "ijiwei")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			json.NewEncoder(w).Encode(map[string]any{"status": 200, "token": "token"})
+		case "/chat/":
+			var params ChatCompletion
+			json.NewDecoder(r.Body).Decode(&params)
+			json.NewEncoder(w).Encode(map[string]any{
+				"status":  200,
+				"choices": []map[string]any{{"message": map[string]any{"role": RoleAssistant, "content": params.Query}}},
+			})
+		}
+	}))
+	defer server.Close()
+
+	var kinds []RequestKind
+	client := NewFengChao("key", "secret", server.URL, WithMiddleware(
+		func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (*Response, error) {
+				kinds = append(kinds, req.Kind)
+				req.Header.Set("X-Tenant", "ijiwei")
+				return next(ctx, req)
+			}
+		},
+		func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (*Response, error) {
+				if req.Params != nil {
+					req.Params.Query = "rewritten: " + req.Params.Query
+				}
+				resp, err := next(ctx, req)
+				if err == nil && resp.Result != nil {
+					resp.Result.Choices[0].Message.Content += " (filtered)"
+				}
+				return resp, err
+			}
+		},
+	))
+
+	res, err := client.ChatCompletion(context.Background(), NewUserMessage("hello"))
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	if want := "rewritten: hello (filtered)"; res.String() != want {
+		t.Errorf("ChatCompletion() = %q, want %q", res.String(), want)
+	}
+	if want := []RequestKind{RequestToken, RequestInvoke}; len(kinds) != 2 || kinds[0] != want[0] || kinds[1] != want[1] {
+		t.Errorf("request kinds = %v, want %v", kinds, want)
+	}
+}
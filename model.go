@@ -2,23 +2,67 @@ package fengchaogo
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Model 模型
 type Model struct {
-	ID             string   `json:"id"`
-	OwnedBy        string   `json:"owned_by"`
-	MaxInputToken  int      `json:"max_input_token"`
-	MaxOutputToken int      `json:"max_output_token"`
-	InPrice        float64  `json:"in_price"`
-	OutPrice       float64  `json:"out_price"`
-	Unit           string   `json:"unit"`
-	Modes          []string `json:"mode"`
-	Channel        string   `json:"channel"`
-	Created        string   `json:"created"`
+	ID             string `json:"id"`
+	OwnedBy        string `json:"owned_by"`
+	MaxInputToken  int    `json:"max_input_token"`
+	MaxOutputToken int    `json:"max_output_token"`
+	// ContextWindow 输入和输出的总长度, 为0时为MaxInputToken与MaxOutputToken之和
+	ContextWindow int      `json:"context_window,omitempty"`
+	InPrice       float64  `json:"in_price"`
+	OutPrice      float64  `json:"out_price"`
+	Unit          string   `json:"unit"`
+	Modes         []string `json:"mode"`
+	Channel       string   `json:"channel"`
+	Created       string   `json:"created"`
+}
+
+// contextWindow 输入和输出的总长度, 模型列表中没有时为MaxInputToken与MaxOutputToken之和
+// 任意一个限制未知时返回0, 不检查总长度
+func (m *Model) contextWindow() int {
+	if m.ContextWindow > 0 {
+		return m.ContextWindow
+	}
+	if m.MaxInputToken <= 0 || m.MaxOutputToken <= 0 {
+		return 0
+	}
+	return m.MaxInputToken + m.MaxOutputToken
+}
+
+// Cost 计算消耗的费用, 价格的单位为Unit, 例如"1k tokens"、"1M tokens", 无法识别时按照每1000个token计算
+func (m *Model) Cost(promptTokens int, completionTokens int) float64 {
+	perUnit := tokensPerUnit(m.Unit)
+	return (float64(promptTokens)*m.InPrice + float64(completionTokens)*m.OutPrice) / perUnit
+}
+
+// tokensPerUnit 价格单位对应的token数
+func tokensPerUnit(unit string) float64 {
+	unit = strings.ToLower(strings.TrimSpace(unit))
+	number, rest := 1.0, unit
+	if i := strings.IndexFunc(unit, func(r rune) bool { return (r < '0' || r > '9') && r != '.' }); i > 0 {
+		if n, err := strconv.ParseFloat(unit[:i], 64); err == nil && n > 0 {
+			number, rest = n, unit[i:]
+		}
+	}
+	switch {
+	case strings.HasPrefix(rest, "m"), strings.HasPrefix(rest, "百万"):
+		return number * 1_000_000
+	case strings.HasPrefix(rest, "k"), strings.HasPrefix(rest, "千"):
+		return number * 1000
+	case rest != unit:
+		return number
+	}
+	return 1000
 }
 
 // modelsResponse 获取模型方法的响应
@@ -26,56 +70,116 @@ type modelsResponse struct {
 	Data []Model `json:"data"`
 }
 
+// ModelsCacheTTL 模型列表的缓存时间
+const ModelsCacheTTL = 24 * time.Hour
+
+// ModelsFailureTTL 模型列表加载失败后的缓存时间, 期间不再重新加载, 直接返回上一次的错误
+const ModelsFailureTTL = 30 * time.Second
+
 // modelsManager 模型管理器
 type modelsManager struct {
-	models     []Model
+	models    []Model
 	updatedAt time.Time
+	// failedAt 最近一次加载失败的时间, 加载成功后清空
+	failedAt time.Time
+	// lastErr 最近一次加载失败的错误
+	lastErr error
+	mu      sync.RWMutex
 }
 
-// GetAvailableModels 获取可用模型
+// GetAvailableModels 获取可用模型, 加载失败时返回nil
 func (f *FengChao) GetAvailableModels() []Model {
-	if f.availableModels == nil || time.Since(f.availableModels.updatedAt) > 24*time.Hour {
-		err := f.loadModels()
-		if err != nil {
-			return nil
-		}
+	models, err := f.availableModelList(context.Background())
+	if err != nil {
+		return nil
 	}
-	return f.availableModels.models
+	return models
+}
+
+// RefreshModels 重新加载可用模型, 加载失败时保留之前的模型列表, 不受ModelsFailureTTL的限制
+func (f *FengChao) RefreshModels(ctx context.Context) error {
+	return f.loadModels(ctx)
+}
+
+// availableModelList 获取可用模型, 没有加载或者缓存过期时重新加载
+// 加载失败后的ModelsFailureTTL内直接返回上一次的错误, 避免模型列表不可用时每次调用都请求服务端
+func (f *FengChao) availableModelList(ctx context.Context) ([]Model, error) {
+	f.availableModels.mu.RLock()
+	models, updatedAt := f.availableModels.models, f.availableModels.updatedAt
+	failedAt, lastErr := f.availableModels.failedAt, f.availableModels.lastErr
+	f.availableModels.mu.RUnlock()
+	if !updatedAt.IsZero() && time.Since(updatedAt) <= ModelsCacheTTL {
+		return models, nil
+	}
+	if !failedAt.IsZero() && time.Since(failedAt) <= ModelsFailureTTL {
+		return nil, lastErr
+	}
+	if err := f.loadModels(ctx); err != nil {
+		return nil, err
+	}
+	f.availableModels.mu.RLock()
+	defer f.availableModels.mu.RUnlock()
+	return f.availableModels.models, nil
 }
 
 // loadModels 加载模型
-func (f *FengChao) loadModels() error {
+func (f *FengChao) loadModels(ctx context.Context) (err error) {
 	// 设置超时
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(BasicRequestTimeout)*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, f.controlPlaneTimeout)
 	defer cancel()
-	resp, err := f.client.R().
-		SetContext(ctx).
-		SetDebug(false).
-		SetLogger(nil).
-		SetResult(&modelsResponse{}).
-		Get("/models/")
+	ctx, span := f.startSpan(ctx, RequestModels, nil)
+	metrics := f.startMetrics(RequestModels, "")
+	defer func() {
+		endSpan(span, nil, err)
+		metrics.finish(nil, err)
+		// 调用方取消时不记录失败
+		if err != nil && ctx.Err() != context.Canceled {
+			f.availableModels.mu.Lock()
+			f.availableModels.failedAt, f.availableModels.lastErr = time.Now(), err
+			f.availableModels.mu.Unlock()
+		}
+	}()
+	start := time.Now()
+	resp, err := f.doFailover(ctx, nil, func(string) *Request {
+		return &Request{
+			Kind:   RequestModels,
+			Method: http.MethodGet,
+			Path:   "/models/",
+		}
+	})
 
 	if err != nil {
-		return fmt.Errorf("get models error: %v", err)
+		f.logger.Error("fengchao models load failed", "latency", time.Since(start), "error", err)
+		return fmt.Errorf("get models error: %w", err)
 	}
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("response error")
+	if resp.StatusCode != 200 {
+		f.logger.Error("fengchao models load failed", "status", resp.StatusCode, "latency", time.Since(start))
+		return fmt.Errorf("get models response error: %w", handleErrorResponse(resp, ""))
 	}
 
-	f.availableModels = &modelsManager{
-		models:     resp.Result().(*modelsResponse).Data,
-		updatedAt: time.Now(),
+	result := &modelsResponse{}
+	if err := json.Unmarshal(resp.Raw, result); err != nil {
+		return fmt.Errorf("get models error: %v", err)
 	}
+	models := result.Data
+	f.logger.Info("fengchao models loaded", "status", resp.StatusCode, "count", len(models), "latency", time.Since(start))
+
+	f.availableModels.mu.Lock()
+	f.availableModels.models = models
+	f.availableModels.updatedAt = time.Now()
+	f.availableModels.failedAt, f.availableModels.lastErr = time.Time{}, nil
+	f.availableModels.mu.Unlock()
 	return nil
 }
 
 // getModel 获取模型
-func (f *FengChao) getModel(name string) (m *Model) {
+func (f *FengChao) getModel(ctx context.Context, name string) (m *Model) {
 	if strings.Contains(name, ",") {
 		name = name[:strings.Index(name, ",")]
 	}
 	// 只校验第一个模型
-	for _, model := range f.GetAvailableModels() {
+	models, _ := f.availableModelList(ctx)
+	for _, model := range models {
 		if name == model.ID {
 			m = &model
 		}
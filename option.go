@@ -40,13 +40,56 @@ func WithMaxTokens(maxTokens int) Option[ChatCompletion] {
 	}
 }
 
-// WithStop 设置停用词
+// WithStop 设置停止序列, 生成的内容遇到任意一个时停止
 func WithStop(stop []string) Option[ChatCompletion] {
 	return func(option *ChatCompletion) {
 		option.Stop = stop
 	}
 }
 
+// WithN 设置生成的结果数量, 通过ChatCompletionResult.Choices返回, 不支持流式请求
+func WithN(n int) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.N = n
+	}
+}
+
+// WithPresencePenalty 设置存在惩罚, 取值范围[-2, 2], 正值鼓励模型谈论新的话题
+func WithPresencePenalty(penalty float64) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.PresencePenalty = penalty
+	}
+}
+
+// WithFrequencyPenalty 设置频率惩罚, 取值范围[-2, 2], 正值降低重复的内容
+func WithFrequencyPenalty(penalty float64) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.FrequencyPenalty = penalty
+	}
+}
+
+// WithSeed 设置随机种子, 相同的种子和参数尽量返回相同的结果
+func WithSeed(seed int64) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.Seed = &seed
+	}
+}
+
+// WithLogprobs 返回输出token的对数概率, topLogprobs为每个位置返回概率最高的token数量, 为0时不返回
+func WithLogprobs(topLogprobs int) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.Logprobs = true
+		option.TopLogprobs = topLogprobs
+	}
+}
+
+// WithResponseFormat 设置输出格式, 可选ResponseFormatText、ResponseFormatJSONObject
+func WithResponseFormat(format string) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.ResponseFormat = &ResponseFormat{Type: format}
+	}
+}
+
 // WithTimeout 设置超时时间
 func WithTimeout(timeout int) Option[ChatCompletion] {
 	return func(option *ChatCompletion) {
@@ -135,3 +178,10 @@ func WithRequestID(requestID string) Option[ChatCompletion] {
 		option.RequestID = requestID
 	}
 }
+
+// WithRetry 设置本次请求的重试策略, 会覆盖客户端的配置
+func WithRetry(policy *RetryPolicy) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.retry = policy
+	}
+}
@@ -0,0 +1,180 @@
+package fengchaogo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy 重试策略, 重试时会复用同一个RequestID, 方便服务端去重
+type RetryPolicy struct {
+	// MaxAttempts 最大尝试次数(包含第一次请求), 小于等于1时不重试
+	MaxAttempts int
+	// InitialBackoff 第一次重试前的等待时间
+	InitialBackoff time.Duration
+	// MaxBackoff 最大等待时间
+	MaxBackoff time.Duration
+	// Multiplier 每次重试等待时间的增长倍数
+	Multiplier float64
+	// Jitter 随机抖动的比例, 取值范围[0, 1]
+	Jitter float64
+	// Retryable 判断错误是否可以重试, 为空时使用IsRetryable
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy 默认的重试策略, 需要通过WithRetryPolicy或WithRetry开启
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// isRetryableStatus 限流和服务端错误可以重试
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// IsRetryable 判断错误是否可以重试: 网络错误、单次请求超时、HTTP 429/5xx 以及业务状态为限流或服务端错误
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.HTTPStatus) || isRetryableStatus(apiErr.Status)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryable 判断错误是否可以重试
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff 计算第attempt次请求失败后的等待时间, 优先使用服务端返回的Retry-After
+func (p *RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	wait := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		wait = wait * (1 + p.Jitter*(rand.Float64()*2-1))
+	}
+	return time.Duration(wait)
+}
+
+// retryPolicy 获取请求的重试策略, 请求的配置优先于客户端的配置
+func (f *FengChao) retryPolicy(params *ChatCompletion) *RetryPolicy {
+	if params.retry != nil {
+		return params.retry
+	}
+	return f.retry
+}
+
+// retrier 记录一次调用的重试状态, 同一次调用的所有请求共享尝试次数
+type retrier struct {
+	f       *FengChao
+	ctx     context.Context
+	params  *ChatCompletion
+	policy  *RetryPolicy
+	attempt int
+}
+
+// newRetrier 创建重试状态
+func (f *FengChao) newRetrier(ctx context.Context, params *ChatCompletion) *retrier {
+	return &retrier{f: f, ctx: ctx, params: params, policy: f.retryPolicy(params), attempt: 1}
+}
+
+// retries 已经重试的次数
+func (r *retrier) retries() int {
+	return r.attempt - 1
+}
+
+// next 判断失败的请求是否需要重试, 需要重试时会等待退避时间后返回true
+func (r *retrier) next(err error) bool {
+	if err == nil || r.policy == nil || r.attempt >= r.policy.MaxAttempts || r.ctx.Err() != nil || !r.policy.retryable(err) {
+		return false
+	}
+
+	wait := r.policy.backoff(r.attempt, err)
+	r.f.logger.Warn("fengchao chat completion retry", "request_id", r.params.RequestID, "model", r.params.Model, "attempt", r.attempt, "backoff", wait, "error", err)
+	trace.SpanFromContext(r.ctx).AddEvent("retry", trace.WithAttributes(
+		attribute.Int("attempt", r.attempt),
+		attribute.Int64("backoff_ms", wait.Milliseconds()),
+		attribute.String("error", err.Error()),
+	))
+	if sleep(r.ctx, wait) != nil {
+		return false
+	}
+	r.attempt++
+	return true
+}
+
+// sleep 等待一段时间, ctx被取消时提前返回
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseRetryAfter 解析Retry-After响应头, 支持秒数和HTTP时间两种格式
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,156 @@
+package fengchaogo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// retryServer 前failures次对话请求返回失败的测试服务
+func retryServer(t *testing.T, failures int, fail func(w http.ResponseWriter, stream bool)) (*httptest.Server, *[]string) {
+	var (
+		mu         sync.Mutex
+		requestIDs []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"status": 200, "token": "token"})
+			return
+		}
+		var params ChatCompletion
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			t.Errorf("decode request body error = %v", err)
+		}
+		mu.Lock()
+		requestIDs = append(requestIDs, params.RequestID)
+		attempt := len(requestIDs)
+		mu.Unlock()
+
+		stream := params.Mode == StreamMode
+		if attempt <= failures {
+			fail(w, stream)
+			return
+		}
+		if stream {
+			fmt.Fprint(w, "event: add\ndata: {\"status\":200,\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":\"hi\"}}]}\n\nevent: stop\ndata: {\"status\":200}\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":  200,
+			"choices": []map[string]any{{"message": map[string]any{"role": RoleAssistant, "content": "hi"}}},
+		})
+	}))
+	return server, &requestIDs
+}
+
+var testRetryPolicy = &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+
+func TestFengChao_ChatCompletionRetry(t *testing.T) {
+	server, requestIDs := retryServer(t, 2, func(w http.ResponseWriter, _ bool) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"detail":"busy"}`)
+	})
+	defer server.Close()
+
+	client := NewFengChao("key", "secret", server.URL, WithRetryPolicy(testRetryPolicy))
+	res, err := client.ChatCompletion(context.Background(), NewUserMessage("hello"))
+	if err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+	if res.String() != "hi" {
+		t.Errorf("ChatCompletion() = %v, want %v", res.String(), "hi")
+	}
+	if len(*requestIDs) != 3 {
+		t.Fatalf("attempts = %d, want %d", len(*requestIDs), 3)
+	}
+	for _, id := range *requestIDs {
+		if id != (*requestIDs)[0] {
+			t.Errorf("request id changed between retries: %v", *requestIDs)
+		}
+	}
+}
+
+func TestFengChao_ChatCompletionNoRetry(t *testing.T) {
+	server, requestIDs := retryServer(t, 1, func(w http.ResponseWriter, _ bool) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"detail":"bad request"}`)
+	})
+	defer server.Close()
+
+	client := NewFengChao("key", "secret", server.URL, WithRetryPolicy(testRetryPolicy))
+	if _, err := client.ChatCompletion(context.Background(), NewUserMessage("hello")); err == nil {
+		t.Fatal("ChatCompletion() error = nil, want error")
+	}
+	if len(*requestIDs) != 1 {
+		t.Errorf("attempts = %d, want %d", len(*requestIDs), 1)
+	}
+}
+
+func TestFengChao_ChatCompletionStreamRetry(t *testing.T) {
+	server, requestIDs := retryServer(t, 1, func(w http.ResponseWriter, _ bool) {
+		fmt.Fprint(w, "event: error\ndata: {\"status\":429,\"msg\":\"too many requests\"}\n\n")
+	})
+	defer server.Close()
+
+	client := NewFengChao("key", "secret", server.URL)
+	reader, err := client.ChatCompletionStream(context.Background(), NewUserMessage("hello"), WithRetry(testRetryPolicy))
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	defer reader.Close()
+
+	chunk, _, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if chunk.String() != "hi" {
+		t.Errorf("Read() = %v, want %v", chunk.String(), "hi")
+	}
+	if len(*requestIDs) != 2 {
+		t.Errorf("attempts = %d, want %d", len(*requestIDs), 2)
+	}
+}
+
+func TestFengChao_ChatCompletionStreamReopenAccounting(t *testing.T) {
+	server, requestIDs := retryServer(t, 1, func(w http.ResponseWriter, _ bool) {
+		fmt.Fprint(w, "event: error\ndata: {\"status\":429,\"msg\":\"too many requests\"}\n\n")
+	})
+	defer server.Close()
+
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Hour})
+	limiter := NewRateLimiter(RateLimit{TokensPerMinute: 10000}, nil)
+	client := NewFengChao("key", "secret", server.URL, WithCircuitBreaker(breaker), WithRateLimiter(limiter))
+	reader, err := client.ChatCompletionStream(context.Background(), NewUserMessage("hello"), WithModel("glm-4"), WithRetry(testRetryPolicy))
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+
+	// 第一个数据包之前的错误计入熔断器, 熔断后不再重新建立数据流
+	_, _, err = reader.Read()
+	reader.Close()
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Read() error = %v, want ErrCircuitOpen", err)
+	}
+	if state := breaker.State("glm-4"); state != CircuitOpen {
+		t.Errorf("state = %v, want open", state)
+	}
+	if len(*requestIDs) != 1 {
+		t.Errorf("attempts = %d, want %d", len(*requestIDs), 1)
+	}
+	// 失败的数据流预估的token数已经归还
+	limiter.mu.Lock()
+	tokens := limiter.buckets["glm-4"].tokens
+	limiter.mu.Unlock()
+	if tokens < 9999 {
+		t.Errorf("limiter tokens = %v, want refunded to 10000", tokens)
+	}
+}
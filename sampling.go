@@ -0,0 +1,200 @@
+package fengchaogo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 输出格式
+const (
+	// ResponseFormatText 文本
+	ResponseFormatText = "text"
+	// ResponseFormatJSONObject json对象, 需要在prompt中说明json的结构
+	ResponseFormatJSONObject = "json_object"
+)
+
+// ResponseFormat 输出格式
+type ResponseFormat struct {
+	// Type 输出格式, 可选ResponseFormatText、ResponseFormatJSONObject
+	Type string `json:"type"`
+}
+
+// Logprobs 输出token的对数概率
+type Logprobs struct {
+	Content []TokenLogprob `json:"content"`
+}
+
+// TokenLogprob 输出token的对数概率
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes,omitempty"`
+	// TopLogprobs 该位置概率最高的token, 设置TopLogprobs时返回
+	TopLogprobs []TopLogprob `json:"top_logprobs,omitempty"`
+}
+
+// TopLogprob 候选token的对数概率
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes,omitempty"`
+}
+
+// ModelCapabilities 模型支持的采样参数及取值范围
+type ModelCapabilities struct {
+	// MaxTemperature temperature的最大值
+	MaxTemperature float64
+	// MaxStop 最多的停止序列数量, 为0时不支持停止序列
+	MaxStop int
+	// MaxN 最多的结果数量, 小于等于1时不支持多个结果
+	MaxN int
+	// Penalties 是否支持presence_penalty和frequency_penalty
+	Penalties bool
+	// Seed 是否支持随机种子
+	Seed bool
+	// Logprobs 是否支持返回对数概率
+	Logprobs bool
+	// MaxTopLogprobs top_logprobs的最大值
+	MaxTopLogprobs int
+	// ResponseFormats 支持的输出格式, 为空时不支持设置输出格式
+	ResponseFormats []string
+}
+
+// ModelCapabilitiesTable 已知模型支持的采样参数, 键为模型名称的前缀, 使用最长匹配的前缀
+// 不在表中的模型只校验通用的取值范围, 由服务端判断是否支持; 可以在创建客户端之前修改
+//
+//	| 模型      | temperature | stop | n     | penalty | seed | logprobs | response_format   |
+//	| gpt-*     | [0, 2]      | 4    | 128   | 支持    | 支持 | 20       | text, json_object |
+//	| glm-*     | [0, 1]      | 1    | 不支持 | 不支持  | 不支持 | 不支持 | text, json_object |
+//	| ERNIE-*   | [0, 1]      | 4    | 不支持 | 支持    | 不支持 | 不支持 | text, json_object |
+var ModelCapabilitiesTable = map[string]ModelCapabilities{
+	"gpt-": {
+		MaxTemperature:  2,
+		MaxStop:         4,
+		MaxN:            128,
+		Penalties:       true,
+		Seed:            true,
+		Logprobs:        true,
+		MaxTopLogprobs:  20,
+		ResponseFormats: []string{ResponseFormatText, ResponseFormatJSONObject},
+	},
+	"glm-": {
+		MaxTemperature:  1,
+		MaxStop:         1,
+		ResponseFormats: []string{ResponseFormatText, ResponseFormatJSONObject},
+	},
+	"ERNIE-": {
+		MaxTemperature:  1,
+		MaxStop:         4,
+		Penalties:       true,
+		ResponseFormats: []string{ResponseFormatText, ResponseFormatJSONObject},
+	},
+}
+
+// LookupModelCapabilities 获取模型支持的采样参数, 多个模型时使用第一个模型, 不在表中时返回false
+func LookupModelCapabilities(model string) (ModelCapabilities, bool) {
+	model, _, _ = strings.Cut(model, ",")
+	var (
+		matched      string
+		capabilities ModelCapabilities
+	)
+	for prefix, c := range ModelCapabilitiesTable {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			matched, capabilities = prefix, c
+		}
+	}
+	return capabilities, matched != ""
+}
+
+// Validate 校验参数的取值范围, 以及模型是否支持设置的参数
+// 超出范围时返回ErrInvalidParameter, 模型不支持时返回ErrUnsupportedParameter, 可以使用errors.Is判断
+func (cc *ChatCompletion) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidParameter}, args...)...))
+	}
+	unsupported := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrUnsupportedParameter}, args...)...))
+	}
+
+	// 通用的取值范围
+	if cc.Temperature < 0 || cc.Temperature > 2 {
+		invalid("temperature must be in [0, 2], got %v", cc.Temperature)
+	}
+	if cc.TopP < 0 || cc.TopP > 1 {
+		invalid("top_p must be in [0, 1], got %v", cc.TopP)
+	}
+	if cc.MaxTokens < 0 {
+		invalid("max_tokens must not be negative, got %d", cc.MaxTokens)
+	}
+	if slices.Contains(cc.Stop, "") {
+		invalid("stop sequences must not be empty")
+	}
+	if cc.N < 0 {
+		invalid("n must not be negative, got %d", cc.N)
+	}
+	if cc.N > 1 && cc.Mode == StreamMode {
+		unsupported("n > 1 is not supported in stream mode")
+	}
+	if cc.PresencePenalty < -2 || cc.PresencePenalty > 2 {
+		invalid("presence_penalty must be in [-2, 2], got %v", cc.PresencePenalty)
+	}
+	if cc.FrequencyPenalty < -2 || cc.FrequencyPenalty > 2 {
+		invalid("frequency_penalty must be in [-2, 2], got %v", cc.FrequencyPenalty)
+	}
+	if cc.TopLogprobs < 0 {
+		invalid("top_logprobs must not be negative, got %d", cc.TopLogprobs)
+	}
+	if cc.TopLogprobs > 0 && !cc.Logprobs {
+		invalid("top_logprobs requires logprobs")
+	}
+	if f := cc.ResponseFormat; f != nil && f.Type != ResponseFormatText && f.Type != ResponseFormatJSONObject {
+		invalid("response_format must be %q or %q, got %q", ResponseFormatText, ResponseFormatJSONObject, f.Type)
+	}
+
+	if cc.ToolChoice != "" && !slices.Contains([]string{ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired}, cc.ToolChoice) {
+		invalid("tool_choice must be %q, %q or %q, got %q", ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired, cc.ToolChoice)
+	}
+	if cc.ToolChoice == ToolChoiceRequired && len(cc.Tools) == 0 {
+		invalid("tool_choice %q requires tools", ToolChoiceRequired)
+	}
+
+	// 模型支持的参数
+	if c, ok := LookupModelCapabilities(cc.Model); ok {
+		model, _, _ := strings.Cut(cc.Model, ",")
+		if cc.Temperature > c.MaxTemperature {
+			invalid("temperature must be in [0, %v] for model %s, got %v", c.MaxTemperature, model, cc.Temperature)
+		}
+		if len(cc.Stop) > 0 && c.MaxStop == 0 {
+			unsupported("model %s does not support stop", model)
+		} else if len(cc.Stop) > c.MaxStop {
+			invalid("model %s supports at most %d stop sequences, got %d", model, c.MaxStop, len(cc.Stop))
+		}
+		if cc.N > 1 && c.MaxN <= 1 {
+			unsupported("model %s does not support n", model)
+		} else if cc.N > c.MaxN && c.MaxN > 1 {
+			invalid("n must be in [1, %d] for model %s, got %d", c.MaxN, model, cc.N)
+		}
+		if (cc.PresencePenalty != 0 || cc.FrequencyPenalty != 0) && !c.Penalties {
+			unsupported("model %s does not support presence_penalty and frequency_penalty", model)
+		}
+		if cc.Seed != nil && !c.Seed {
+			unsupported("model %s does not support seed", model)
+		}
+		if cc.Logprobs && !c.Logprobs {
+			unsupported("model %s does not support logprobs", model)
+		} else if cc.TopLogprobs > c.MaxTopLogprobs && c.Logprobs {
+			invalid("top_logprobs must be in [0, %d] for model %s, got %d", c.MaxTopLogprobs, model, cc.TopLogprobs)
+		}
+		if f := cc.ResponseFormat; f != nil && !slices.Contains(c.ResponseFormats, f.Type) {
+			unsupported("model %s does not support response_format %q", model, f.Type)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid chat completion params: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+func TestSamplingParams(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{Choices: []string{"第一个", "第二个"}})
+	client := server.Client()
+
+	result, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"),
+		fengchao.WithModel("gpt-4o"),
+		fengchao.WithStop([]string{"\n\n", "END"}),
+		fengchao.WithN(2),
+		fengchao.WithPresencePenalty(0.5),
+		fengchao.WithFrequencyPenalty(-0.5),
+		fengchao.WithSeed(42),
+		fengchao.WithLogprobs(5),
+		fengchao.WithResponseFormat(fengchao.ResponseFormatJSONObject),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Contents(); !slices.Equal(got, []string{"第一个", "第二个"}) {
+		t.Fatalf("got contents %v", got)
+	}
+
+	req := server.LastRequest()
+	if !slices.Equal(req.Stop, []string{"\n\n", "END"}) {
+		t.Fatalf("got stop %v, want it sent to the server", req.Stop)
+	}
+	if req.N != 2 || req.PresencePenalty != 0.5 || req.FrequencyPenalty != -0.5 {
+		t.Fatalf("got n=%d presence_penalty=%v frequency_penalty=%v", req.N, req.PresencePenalty, req.FrequencyPenalty)
+	}
+	if req.Seed == nil || *req.Seed != 42 {
+		t.Fatalf("got seed %v, want 42", req.Seed)
+	}
+	if !req.Logprobs || req.TopLogprobs != 5 {
+		t.Fatalf("got logprobs=%v top_logprobs=%d", req.Logprobs, req.TopLogprobs)
+	}
+	if req.ResponseFormat == nil || req.ResponseFormat.Type != fengchao.ResponseFormatJSONObject {
+		t.Fatalf("got response_format %+v", req.ResponseFormat)
+	}
+}
+
+func TestSamplingParamsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []fengchao.Option[fengchao.ChatCompletion]
+		want    error
+	}{
+		{"valid", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("ERNIE-Bot-4"), fengchao.WithStop([]string{"END"}), fengchao.WithPresencePenalty(1)}, nil},
+		{"unknown model", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("qwen-max"), fengchao.WithN(3), fengchao.WithSeed(1)}, nil},
+		{"temperature", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("qwen-max"), fengchao.WithTemperature(2.5)}, fengchao.ErrInvalidParameter},
+		{"model temperature", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("glm-4"), fengchao.WithTemperature(1.5)}, fengchao.ErrInvalidParameter},
+		{"penalty", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("gpt-4o"), fengchao.WithFrequencyPenalty(3)}, fengchao.ErrInvalidParameter},
+		{"top logprobs", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("gpt-4o"), fengchao.WithLogprobs(21)}, fengchao.ErrInvalidParameter},
+		{"stop count", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("glm-4"), fengchao.WithStop([]string{"a", "b"})}, fengchao.ErrInvalidParameter},
+		{"response format", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithResponseFormat("xml")}, fengchao.ErrInvalidParameter},
+		{"n", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("ERNIE-Bot-4"), fengchao.WithN(2)}, fengchao.ErrUnsupportedParameter},
+		{"seed", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("glm-4"), fengchao.WithSeed(1)}, fengchao.ErrUnsupportedParameter},
+		{"logprobs", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("ERNIE-Bot-4"), fengchao.WithLogprobs(0)}, fengchao.ErrUnsupportedParameter},
+		{"penalties", []fengchao.Option[fengchao.ChatCompletion]{fengchao.WithModel("glm-4"), fengchao.WithPresencePenalty(1)}, fengchao.ErrUnsupportedParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fengchao.NewChatCompletion(tt.options...).Validate()
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSamplingParamsRejected(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	_, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"), fengchao.WithModel("glm-4"), fengchao.WithSeed(1))
+	if !errors.Is(err, fengchao.ErrUnsupportedParameter) {
+		t.Fatalf("got %v, want ErrUnsupportedParameter", err)
+	}
+	_, err = client.ChatCompletionStream(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好"), fengchao.WithModel("gpt-4o"), fengchao.WithN(2))
+	if !errors.Is(err, fengchao.ErrUnsupportedParameter) {
+		t.Fatalf("got %v, want ErrUnsupportedParameter for stream", err)
+	}
+	if len(server.Requests()) != 0 {
+		t.Fatalf("got %d requests, want rejected before sending", len(server.Requests()))
+	}
+}
@@ -0,0 +1,236 @@
+package fengchaogo
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JSONSchema JSON Schema的子集, 用于描述结构化输出和工具的参数
+type JSONSchema struct {
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Format 字符串的格式, 例如date-time
+	Format string `json:"format,omitempty"`
+	// Enum 可选的值
+	Enum []any `json:"enum,omitempty"`
+	// Properties 对象的字段
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	// Required 对象必须包含的字段
+	Required []string `json:"required,omitempty"`
+	// Items 数组的元素
+	Items *JSONSchema `json:"items,omitempty"`
+	// AdditionalProperties map的值
+	AdditionalProperties *JSONSchema `json:"additionalProperties,omitempty"`
+}
+
+// SchemaFor 根据类型生成JSON Schema
+// 字段名称使用json标签, 没有omitempty且不是指针的字段为必须字段
+// 可以使用description标签添加说明, 使用enum标签设置逗号分隔的可选值
+func SchemaFor[T any]() *JSONSchema {
+	return schemaOf(reflect.TypeFor[T](), map[reflect.Type]bool{})
+}
+
+var (
+	timeType            = reflect.TypeFor[time.Time]()
+	rawMessageType      = reflect.TypeFor[json.RawMessage]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// schemaOf 生成类型的JSON Schema, visiting用于处理递归的类型
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) *JSONSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &JSONSchema{}
+	case reflect.PointerTo(t).Implements(textUnmarshalerType):
+		return &JSONSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte使用base64编码
+			return &JSONSchema{Type: "string"}
+		}
+		return &JSONSchema{Type: "array", Items: schemaOf(t.Elem(), visiting)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			return &JSONSchema{Type: "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+		addFields(schema, t, visiting)
+		return schema
+	}
+	// interface等无法确定类型的值
+	return &JSONSchema{}
+}
+
+// addFields 将结构体的字段添加到对象的JSON Schema中, 匿名的结构体字段会展开
+func addFields(schema *JSONSchema, t reflect.Type, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addFields(schema, embedded, visiting)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := schemaOf(field.Type, visiting)
+		property.Description = field.Tag.Get("description")
+		if enum := field.Tag.Get("enum"); enum != "" {
+			for _, v := range strings.Split(enum, ",") {
+				property.Enum = append(property.Enum, strings.TrimSpace(v))
+			}
+		}
+		schema.Properties[name] = property
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// String 格式化为json
+func (s *JSONSchema) String() string {
+	data, _ := json.MarshalIndent(s, "", "  ")
+	return string(data)
+}
+
+// Validate 校验json是否符合JSON Schema, 返回所有不符合的位置
+func (s *JSONSchema) Validate(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid json: %w", err)
+	}
+	var errs []error
+	s.validate("$", value, &errs)
+	return errors.Join(errs...)
+}
+
+// validate 校验值是否符合JSON Schema
+func (s *JSONSchema) validate(path string, value any, errs *[]error) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, fmt.Errorf("%s: "+format, append([]any{path}, args...)...))
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(v any) bool { return fmt.Sprint(v) == fmt.Sprint(value) }) {
+		fail("must be one of %v, got %v", s.Enum, value)
+		return
+	}
+
+	switch s.Type {
+	case "":
+		return
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean, got %s", jsonType(value))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			fail("must be an integer, got %s", jsonType(value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			fail("must be a number, got %s", jsonType(value))
+		}
+	case "string":
+		v, ok := value.(string)
+		if !ok {
+			fail("must be a string, got %s", jsonType(value))
+		} else if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				fail("must be an RFC 3339 date-time, got %q", v)
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			fail("must be an array, got %s", jsonType(value))
+			return
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			fail("must be an object, got %s", jsonType(value))
+			return
+		}
+		for _, name := range s.Required {
+			if v, ok := object[name]; !ok || v == nil {
+				fail("missing required field %q", name)
+			}
+		}
+		for name, v := range object {
+			property, ok := s.Properties[name]
+			if !ok {
+				property = s.AdditionalProperties
+			}
+			// 可选字段可以为null
+			if property == nil || v == nil {
+				continue
+			}
+			property.validate(path+"."+name, v, errs)
+		}
+	}
+}
+
+// jsonType 获取json值的类型名称
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"iter"
-	"net/http"
 )
 
 const (
@@ -62,15 +61,62 @@ type StreamReader[T StreamAble] interface {
 
 // JsonStreamReader Json流式数据读取器
 type JsonStreamReader[T StreamAble] struct {
-	reader *bufio.Reader  // reader 用于读取数据
-	resp   *http.Response // resp 用于关闭resp.Body
+	reader *bufio.Reader // reader 用于读取数据
+	body   io.ReadCloser // body 用于关闭数据流
 
 	errorHandler func(T) error // 处理错误
+
+	delivered bool                               // 是否已经返回过数据包
+	reopen    func(error) (io.ReadCloser, error) // 重新建立数据流, 只在没有返回过数据包时使用
+
+	onMessage []func(*T)    // 返回数据包时的回调
+	onClose   []func(error) // 关闭数据流时的回调, 参数为读取时遇到的最后一个错误
+	closed    bool          // 是否已经关闭
+	err       error         // 读取时遇到的最后一个错误
+}
+
+// OnMessage 注册返回数据包时的回调
+func (j *JsonStreamReader[T]) OnMessage(fn func(*T)) {
+	j.onMessage = append(j.onMessage, fn)
+}
+
+// OnClose 注册关闭数据流时的回调, 只会调用一次, 参数为读取时遇到的最后一个错误
+func (j *JsonStreamReader[T]) OnClose(fn func(error)) {
+	j.onClose = append(j.onClose, fn)
 }
 
 // Read 读取数据直到获得一个完整的数据包, 或者遇到错误或者遇到结束事件(包括EOF), 但一般情况不会遇到EOF
 // 需要自定义处理数据流可以使用这个方法, 一般使用Stream方法, 可以更轻松的处理数据流
+// 在返回第一个数据包之前遇到可以重试的错误时, 会按照重试策略重新建立数据流
 func (j *JsonStreamReader[T]) Read() (*T, bool, error) {
+	for {
+		msg, finished, err := j.read()
+		if err != nil && !j.delivered && j.reopen != nil {
+			body, reopenErr := j.reopen(err)
+			if reopenErr != nil {
+				j.err = reopenErr
+				return msg, finished, reopenErr
+			}
+			j.body.Close()
+			j.body = body
+			j.reader = bufio.NewReader(body)
+			continue
+		}
+		if err != nil {
+			j.err = err
+		}
+		if msg != nil {
+			j.delivered = true
+			for _, fn := range j.onMessage {
+				fn(msg)
+			}
+		}
+		return msg, finished, err
+	}
+}
+
+// read 读取一个数据包
+func (j *JsonStreamReader[T]) read() (*T, bool, error) {
 	var tmpbuffer = make([]byte, 0)
 	isFinished := false
 	catchError := false
@@ -171,5 +217,11 @@ func (j *JsonStreamReader[T]) Stream() iter.Seq[T] {
 
 // Close 关闭数据流
 func (j *JsonStreamReader[T]) Close() error {
-	return j.resp.Body.Close()
+	if !j.closed {
+		j.closed = true
+		for _, fn := range j.onClose {
+			fn(j.err)
+		}
+	}
+	return j.body.Close()
 }
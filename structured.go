@@ -0,0 +1,134 @@
+package fengchaogo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultOutputRepairs 结构化输出不符合要求时默认重新生成的次数
+const DefaultOutputRepairs = 2
+
+// ErrInvalidOutput 模型的输出不符合结构化输出的要求
+var ErrInvalidOutput = errors.New("invalid structured output")
+
+// WithOutputRepairs 设置结构化输出不符合要求时, 携带错误信息重新生成的次数, 为0时不重新生成
+func WithOutputRepairs(repairs int) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.outputRepairs = &repairs
+	}
+}
+
+// ChatCompletionInto 聊天并将结果解析为T
+// 根据T生成JSON Schema并添加到系统消息中, 从结果中提取json并校验, 不符合要求时携带错误信息重新生成
+// 返回的ChatCompletionResult为最后一次生成的结果, Usage为所有生成的累计消耗
+func ChatCompletionInto[T any](ctx context.Context, f *FengChao, prompt Prompt, chatCompletionOption ...Option[ChatCompletion]) (T, *ChatCompletionResult, error) {
+	var output T
+	params := f.newChatCompletion(chatCompletionOption...)
+	originalMessages, err := params.LoadPromptTemplates(prompt)
+	if err != nil {
+		return output, nil, fmt.Errorf("fail to load prompt template cause: %w", err)
+	}
+
+	schema := SchemaFor[T]()
+	params.System = strings.TrimSpace(params.System + "\n\n" + structuredOutputInstruction(schema))
+	if params.ResponseFormat == nil && schema.Type == "object" {
+		if c, ok := LookupModelCapabilities(params.Model); ok && slices.Contains(c.ResponseFormats, ResponseFormatJSONObject) {
+			params.ResponseFormat = &ResponseFormat{Type: ResponseFormatJSONObject}
+		}
+	}
+	repairs := DefaultOutputRepairs
+	if params.outputRepairs != nil {
+		repairs = *params.outputRepairs
+	}
+
+	var usage ChatCompletionResult
+	for attempt := 0; ; attempt++ {
+		result, err := f.chat(ctx, RequestInvoke, params)
+		if result != nil {
+			usage.Usage.PromptTokens += result.Usage.PromptTokens
+			usage.Usage.CompletionTokens += result.Usage.CompletionTokens
+			usage.Usage.TotalTokens += result.Usage.TotalTokens
+		}
+		if err != nil {
+			return output, result, err
+		}
+		result.Usage = usage.Usage
+		content := result.String()
+		result.History = append(originalMessages, &Message{Role: RoleAssistant, Content: content})
+
+		err = decodeStructuredOutput(content, schema, &output)
+		if err == nil {
+			return output, result, nil
+		}
+		if attempt >= repairs {
+			return output, result, fmt.Errorf("%w after %d attempts: %w", ErrInvalidOutput, attempt+1, err)
+		}
+		f.logger.Warn("fengchao structured output invalid, repairing", "request_id", params.RequestID, "attempt", attempt+1, "error", err)
+
+		// 携带上一次的输出和错误信息重新生成
+		history := append(slices.Clone(params.History),
+			&Message{Role: RoleUser, Content: params.Query, Parts: params.QueryParts},
+			&Message{Role: RoleAssistant, Content: content},
+		)
+		params = params.Clone()
+		params.History = history
+		params.Query = structuredOutputRepair(err)
+		params.QueryParts = nil
+	}
+}
+
+// structuredOutputInstruction 结构化输出的格式要求
+func structuredOutputInstruction(schema *JSONSchema) string {
+	return "请只输出一个符合以下JSON Schema的JSON, 不要输出解释或者其他内容:\n```json\n" + schema.String() + "\n```"
+}
+
+// structuredOutputRepair 要求重新生成的消息
+func structuredOutputRepair(err error) string {
+	return "上一次的输出不符合要求:\n" + err.Error() + "\n请修正后重新输出完整的JSON, 不要输出解释或者其他内容。"
+}
+
+// decodeStructuredOutput 从内容中提取json, 校验后解析到output
+func decodeStructuredOutput(content string, schema *JSONSchema, output any) error {
+	raw, err := extractJSON(content)
+	if err != nil {
+		return err
+	}
+	if err := schema.Validate(raw); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, output); err != nil {
+		return fmt.Errorf("decode json: %w", err)
+	}
+	return nil
+}
+
+// extractJSON 从内容中提取第一个完整的json值, 支持markdown代码块以及前后的说明文字
+func extractJSON(content string) (json.RawMessage, error) {
+	content = strings.TrimSpace(content)
+	if json.Valid([]byte(content)) {
+		return json.RawMessage(content), nil
+	}
+	// 优先使用代码块中的内容
+	if _, block, ok := strings.Cut(content, "```"); ok {
+		if block, _, ok = strings.Cut(block, "```"); ok {
+			block = strings.TrimPrefix(block, "json")
+			if block = strings.TrimSpace(block); json.Valid([]byte(block)) {
+				return json.RawMessage(block), nil
+			}
+		}
+	}
+	for i, c := range content {
+		if c != '{' && c != '[' {
+			continue
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(content[i:])).Decode(&raw); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, errors.New("no valid json found in the output")
+}
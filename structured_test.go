@@ -0,0 +1,139 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+type movie struct {
+	Title  string   `json:"title" description:"片名"`
+	Year   int      `json:"year"`
+	Genre  string   `json:"genre" enum:"comedy,drama,action"`
+	Actors []string `json:"actors,omitempty"`
+	Rating *float64 `json:"rating"`
+}
+
+func TestChatCompletionInto(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{
+		Content:      "好的, 结果如下:\n```json\n{\"title\": \"大话西游\", \"year\": 1995, \"genre\": \"comedy\", \"actors\": [\"周星驰\"]}\n```\n希望对你有帮助",
+		PromptTokens: 10, CompletionTokens: 20,
+	})
+	client := server.Client()
+
+	got, result, err := fengchao.ChatCompletionInto[movie](context.Background(), client,
+		fengchao.NewPromptTemplate(
+			fengchao.NewMessage(fengchao.RoleSystem, "你是一个电影专家"),
+			fengchao.NewMessage(fengchao.RoleUser, "推荐一部电影"),
+		),
+		fengchao.WithModel("gpt-4o"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "大话西游" || got.Year != 1995 || got.Genre != "comedy" || len(got.Actors) != 1 || got.Rating != nil {
+		t.Fatalf("got %+v", got)
+	}
+	if result.Usage.TotalTokens != 30 {
+		t.Fatalf("got usage %+v", result.Usage)
+	}
+
+	req := server.LastRequest()
+	if !strings.HasPrefix(req.System, "你是一个电影专家") || !strings.Contains(req.System, `"title"`) || !strings.Contains(req.System, "片名") {
+		t.Fatalf("schema not injected into system message: %s", req.System)
+	}
+	if req.ResponseFormat == nil || req.ResponseFormat.Type != fengchao.ResponseFormatJSONObject {
+		t.Fatalf("got response_format %+v, want json_object", req.ResponseFormat)
+	}
+}
+
+func TestChatCompletionIntoRepair(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnQuery("推荐一部电影",
+		fengchaotest.Reply{Content: `{"title": "大话西游", "year": "1995年", "genre": "comedy"}`, PromptTokens: 10, CompletionTokens: 10},
+	)
+	server.SetDefault(fengchaotest.Reply{Content: `{"title": "大话西游", "year": 1995, "genre": "comedy"}`, PromptTokens: 30, CompletionTokens: 10})
+	client := server.Client()
+
+	got, result, err := fengchao.ChatCompletionInto[movie](context.Background(), client, fengchao.NewMessage(fengchao.RoleUser, "推荐一部电影"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Year != 1995 {
+		t.Fatalf("got %+v", got)
+	}
+	if result.Usage.TotalTokens != 60 {
+		t.Fatalf("got total tokens %d, want usage of both attempts", result.Usage.TotalTokens)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	repair := requests[1]
+	if !strings.Contains(repair.Query, "$.year: must be an integer") {
+		t.Fatalf("repair query does not contain the error: %s", repair.Query)
+	}
+	if len(repair.History) != 2 || repair.History[0].Content != "推荐一部电影" || repair.History[1].Role != fengchao.RoleAssistant {
+		t.Fatalf("repair history does not contain the previous attempt: %+v", repair.History)
+	}
+	if repair.RequestID == requests[0].RequestID {
+		t.Fatal("repair request reused the request id")
+	}
+}
+
+func TestChatCompletionIntoInvalid(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{Content: "抱歉, 我无法推荐"})
+	client := server.Client()
+
+	_, result, err := fengchao.ChatCompletionInto[movie](context.Background(), client, fengchao.NewMessage(fengchao.RoleUser, "推荐一部电影"), fengchao.WithOutputRepairs(1))
+	if !errors.Is(err, fengchao.ErrInvalidOutput) {
+		t.Fatalf("got %v, want ErrInvalidOutput", err)
+	}
+	if result == nil || result.String() != "抱歉, 我无法推荐" {
+		t.Fatalf("got result %v, want the last output", result)
+	}
+	if len(server.Requests()) != 2 {
+		t.Fatalf("got %d requests, want 2", len(server.Requests()))
+	}
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema := fengchao.SchemaFor[movie]()
+	if schema.Type != "object" || strings.Join(schema.Required, ",") != "title,year,genre" {
+		t.Fatalf("got type %s required %v", schema.Type, schema.Required)
+	}
+	if p := schema.Properties["actors"]; p.Type != "array" || p.Items.Type != "string" {
+		t.Fatalf("got actors %+v", p)
+	}
+	if p := schema.Properties["rating"]; p.Type != "number" {
+		t.Fatalf("got rating %+v", p)
+	}
+
+	tests := []struct {
+		json string
+		want string
+	}{
+		{`{"title": "a", "year": 1, "genre": "drama", "rating": null}`, ""},
+		{`{"title": "a", "year": 1.5, "genre": "drama"}`, "$.year: must be an integer"},
+		{`{"title": "a", "year": 1, "genre": "horror"}`, "$.genre: must be one of"},
+		{`{"year": 1, "genre": "drama"}`, `missing required field "title"`},
+		{`{"title": "a", "year": 1, "genre": "drama", "actors": [1]}`, "$.actors[0]: must be a string"},
+		{`[]`, "$: must be an object"},
+	}
+	for _, tt := range tests {
+		err := schema.Validate([]byte(tt.json))
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("validate %s: got %v, want %q", tt.json, err, tt.want)
+		}
+	}
+}
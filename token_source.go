@@ -0,0 +1,183 @@
+package fengchaogo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token 来源签发的token
+type Token struct {
+	// Value token
+	Value string
+	// ExpiresAt 过期时间, 为零值时使用JWT中的exp, 都没有时使用ExpiresTime
+	ExpiresAt time.Time
+}
+
+// TokenSource 获取token的来源, 客户端会按照服务地址和凭证缓存返回的token直到过期,
+// 过期、即将过期或者被服务端拒绝时再次获取, 同一时间只会有一个获取的请求
+type TokenSource interface {
+	// Token 获取服务地址使用的token
+	Token(ctx context.Context, endpoint string) (*Token, error)
+}
+
+// TokenSourceFunc 使用函数实现TokenSource
+type TokenSourceFunc func(ctx context.Context, endpoint string) (*Token, error)
+
+// Token 获取服务地址使用的token
+func (fn TokenSourceFunc) Token(ctx context.Context, endpoint string) (*Token, error) {
+	return fn(ctx, endpoint)
+}
+
+// KeySecretTokenSource 使用api_key和secret_key从服务地址的/token接口换取token
+// 在客户端中使用时会通过客户端的中间件、链路追踪和指标发送请求, 单独使用时通过Client发送请求
+type KeySecretTokenSource struct {
+	// ApiKey fengchao api key
+	ApiKey string
+	// SecretKey fengchao secret key
+	SecretKey string
+	// Client 单独使用时的http客户端, 为空时使用http.DefaultClient
+	Client *http.Client
+}
+
+// Token 从服务地址换取token
+func (s *KeySecretTokenSource) Token(ctx context.Context, endpoint string) (*Token, error) {
+	query := url.Values{"api_key": {s.ApiKey}, "secret_key": {s.SecretKey}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(endpoint, "/")+"/token?"+query.Encode(), nil)
+	if err != nil {
+		return nil, tokenClientError(err)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, tokenClientError(err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, tokenClientError(err)
+	}
+	return parseTokenResponse(&Response{StatusCode: resp.StatusCode, Header: resp.Header, Raw: raw})
+}
+
+// staticTokenSource 固定的token
+type staticTokenSource struct {
+	token string
+}
+
+// StaticTokenSource 使用固定的token, 适用于由其他服务签发的长期token
+func StaticTokenSource(token string) TokenSource {
+	return &staticTokenSource{token: token}
+}
+
+// Token 返回固定的token
+func (s *staticTokenSource) Token(context.Context, string) (*Token, error) {
+	if s.token == "" {
+		return nil, errors.New("static token is empty")
+	}
+	return &Token{Value: s.token}, nil
+}
+
+// DefaultTokenFileInterval 配置文件中token_file检查文件变化的间隔
+const DefaultTokenFileInterval = time.Minute
+
+// fileTokenSource 从文件中读取token
+type fileTokenSource struct {
+	path     string
+	interval time.Duration
+
+	token   string
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+// FileTokenSource 从文件中读取token, 例如由sidecar定期写入的token文件
+// token最多缓存interval, 之后检查文件的修改时间, 文件变化时重新读取, 小于等于0时只在token过期或者被拒绝时读取
+func FileTokenSource(path string, interval time.Duration) TokenSource {
+	return &fileTokenSource{path: path, interval: interval}
+}
+
+// Token 读取文件中的token, 文件没有变化时使用上一次读取的内容
+func (s *fileTokenSource) Token(context.Context, string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("read token file error: %w", err)
+	}
+	if s.token == "" || !info.ModTime().Equal(s.modTime) {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return nil, fmt.Errorf("read token file error: %w", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return nil, fmt.Errorf("token file %s is empty", s.path)
+		}
+		s.token = token
+		s.modTime = info.ModTime()
+	}
+
+	token := &Token{Value: s.token}
+	if s.interval > 0 {
+		token.ExpiresAt = time.Now().Add(s.interval)
+		if exp, ok := jwtExpiry(s.token); ok && exp.Before(token.ExpiresAt) {
+			token.ExpiresAt = exp
+		}
+	}
+	return token, nil
+}
+
+// commandTokenSource 执行外部命令获取token
+type commandTokenSource struct {
+	name string
+	args []string
+}
+
+// CommandTokenSource 执行外部命令获取token, 服务地址通过环境变量FENGCHAO_ENDPOINT传入
+// 命令的标准输出为token, 或者与/token接口相同格式的json(包含token以及可选的expires_in、expires_at)
+func CommandTokenSource(name string, args ...string) TokenSource {
+	return &commandTokenSource{name: name, args: args}
+}
+
+// Token 执行命令获取token
+func (s *commandTokenSource) Token(ctx context.Context, endpoint string) (*Token, error) {
+	cmd := exec.CommandContext(ctx, s.name, s.args...)
+	cmd.Env = append(os.Environ(), "FENGCHAO_ENDPOINT="+endpoint)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("token command %s error: %w: %s", s.name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	output = bytes.TrimSpace(output)
+
+	if bytes.HasPrefix(output, []byte("{")) {
+		result := &tokenResponse{}
+		if err := json.Unmarshal(output, result); err != nil {
+			return nil, fmt.Errorf("token command %s output error: %w", s.name, err)
+		}
+		if result.Token == "" {
+			return nil, fmt.Errorf("token command %s output has no token", s.name)
+		}
+		return &Token{Value: result.Token, ExpiresAt: result.expiresAt(time.Now())}, nil
+	}
+	if len(output) == 0 {
+		return nil, fmt.Errorf("token command %s output is empty", s.name)
+	}
+	return &Token{Value: string(output)}, nil
+}
@@ -0,0 +1,181 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+func TestStaticTokenSource(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.Token = "static-token"
+
+	client := fengchao.NewFengChao("", "", server.URL, fengchao.WithTokenSource(fengchao.StaticTokenSource("static-token")))
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+	if server.TokenRequests() != 0 {
+		t.Fatalf("got %d token requests, want 0", server.TokenRequests())
+	}
+}
+
+func TestFileTokenSource(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.Token = "file-token"
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client := fengchao.NewFengChao("", "", server.URL,
+		fengchao.WithTokenSource(fengchao.FileTokenSource(path, 0)),
+		fengchao.WithRetryPolicy(&fengchao.RetryPolicy{MaxAttempts: 1}),
+	)
+	ctx := context.Background()
+	if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+
+	// sidecar轮换了token并写入文件, 旧token被拒绝后重新读取文件
+	server.RevokeTokens()
+	if err := os.WriteFile(path, []byte("file-token-1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ChatCompletion(ctx, fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.Headers()[2].Get("Authorization"); got != "file-token-1" {
+		t.Fatalf("got token %q, want the rotated token", got)
+	}
+}
+
+func TestCommandTokenSource(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.Token = "command-token"
+
+	source := fengchao.CommandTokenSource("sh", "-c", `test "$FENGCHAO_ENDPOINT" = "`+server.URL+`" && echo '{"token":"command-token","expires_in":600}'`)
+	token, err := source.Token(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Value != "command-token" || time.Until(token.ExpiresAt) < 590*time.Second {
+		t.Fatalf("unexpected token: %+v", token)
+	}
+
+	client := fengchao.NewFengChao("", "", server.URL, fengchao.WithTokenSource(source))
+	if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fengchao.CommandTokenSource("sh", "-c", "echo vault sealed >&2; exit 1").Token(context.Background(), server.URL)
+	if err == nil || !strings.Contains(err.Error(), "vault sealed") {
+		t.Fatalf("got %v, want command error with stderr", err)
+	}
+}
+
+func TestCustomTokenSource(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+
+	var calls atomic.Int32
+	source := fengchao.TokenSourceFunc(func(ctx context.Context, endpoint string) (*fengchao.Token, error) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return &fengchao.Token{Value: fengchaotest.DefaultToken, ExpiresAt: time.Now().Add(time.Hour)}, nil
+	})
+	client := fengchao.NewFengChao("", "", server.URL, fengchao.WithTokenSource(source))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.ChatCompletion(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "你好")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("got %d token source calls, want 1", calls.Load())
+	}
+}
+
+func TestExpiredTokenSource(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+
+	source := fengchao.TokenSourceFunc(func(ctx context.Context, endpoint string) (*fengchao.Token, error) {
+		return &fengchao.Token{Value: fengchaotest.DefaultToken, ExpiresAt: time.Now().Add(-time.Minute)}, nil
+	})
+	client := fengchao.NewFengChao("", "", server.URL, fengchao.WithTokenSource(source))
+
+	// 来源返回已经过期的token时返回错误, 不发送空的Authorization
+	if err := client.Authenticate(context.Background()); !errors.Is(err, fengchao.ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+	_, err := client.ChatCompletion(context.Background(), fengchao.NewUserMessage("你好"))
+	if !errors.Is(err, fengchao.ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+	if len(server.Requests()) != 0 {
+		t.Fatalf("got %d requests, want 0", len(server.Requests()))
+	}
+}
+
+func TestKeySecretTokenSource(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+
+	source := &fengchao.KeySecretTokenSource{ApiKey: "key", SecretKey: "secret"}
+	token, err := source.Token(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Value != fengchaotest.DefaultToken {
+		t.Fatalf("got %q, want %q", token.Value, fengchaotest.DefaultToken)
+	}
+
+	if _, err := (&fengchao.KeySecretTokenSource{}).Token(context.Background(), server.URL); err == nil {
+		t.Fatal("want error without api_key")
+	}
+}
+
+func TestKeySecretTokenSourceRedactsSecret(t *testing.T) {
+	const secretKey = "secret-key-1234567890"
+	// 关闭的服务地址, 返回连接错误
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	source := &fengchao.KeySecretTokenSource{ApiKey: "key", SecretKey: secretKey}
+	_, err := source.Token(context.Background(), server.URL)
+	if err == nil {
+		t.Fatal("want connection error")
+	}
+	if strings.Contains(err.Error(), secretKey) || !strings.Contains(err.Error(), server.URL+"/token") {
+		t.Fatalf("got %v, want error without secret_key", err)
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) || strings.Contains(urlErr.URL, secretKey) {
+		t.Fatalf("got %v, want *url.Error without secret_key", err)
+	}
+}
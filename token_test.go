@@ -0,0 +1,50 @@
+package fengchaogo
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestTokenResponseExpiresAt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		response tokenResponse
+		want     time.Time
+	}{
+		{"expires_in", tokenResponse{ExpiresIn: 300, ExpiresAt: 1700000900}, now.Add(300 * time.Second)},
+		{"expires_at", tokenResponse{ExpiresAt: 1700000900}, time.Unix(1700000900, 0)},
+		{"none", tokenResponse{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.response.expiresAt(now); !got.Equal(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenExpiresAt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	jwt := "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1700000600}`)) + ".signature"
+
+	tests := []struct {
+		name  string
+		token Token
+		want  time.Time
+	}{
+		{"source", Token{Value: jwt, ExpiresAt: time.Unix(1700000900, 0)}, time.Unix(1700000900, 0)},
+		{"jwt", Token{Value: jwt}, time.Unix(1700000600, 0)},
+		{"fallback", Token{Value: "opaque-token"}, now.Add(time.Duration(ExpiresTime) * time.Second)},
+		{"invalid jwt", Token{Value: "a.b.c"}, now.Add(time.Duration(ExpiresTime) * time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenExpiresAt(&tt.token, now); !got.Equal(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
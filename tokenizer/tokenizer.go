@@ -0,0 +1,157 @@
+// Package tokenizer 提供本地的token数估算, 用于在发送请求之前预估消耗和检查上下文长度
+//
+// 默认使用Estimator按照字符类别估算, 中日韩字符、英文单词、数字和标点分别计算, 适合中英文混合的文本;
+// 不同模型的分词方式不同, 可以通过Register为模型注册Tokenizer, 例如接入精确的BPE分词器
+package tokenizer
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Tokenizer 计算文本的token数
+type Tokenizer interface {
+	Count(text string) int
+}
+
+// TokenizerFunc 使用函数实现Tokenizer
+type TokenizerFunc func(text string) int
+
+// Count 计算文本的token数
+func (f TokenizerFunc) Count(text string) int {
+	return f(text)
+}
+
+// Estimator 按照字符类别估算token数
+type Estimator struct {
+	// CJK 每个中日韩字符的token数
+	CJK float64
+	// WordChars 英文单词每多少个字符计为一个token, 不足时按一个计算
+	WordChars int
+	// DigitChars 连续的数字每多少个计为一个token, 不足时按一个计算
+	DigitChars int
+}
+
+var _ Tokenizer = (*Estimator)(nil)
+
+// Default 默认的估算器, 没有为模型注册Tokenizer时使用
+var Default = &Estimator{CJK: 1, WordChars: 6, DigitChars: 3}
+
+// Count 估算文本的token数, 空格不计算, 连续的换行计为一个token, 其他标点和符号每个计为一个token
+func (e *Estimator) Count(text string) int {
+	var (
+		cjk    int
+		tokens int
+		// run 当前连续的单词或者数字的字符数, kind为其类别
+		run  int
+		kind rune
+	)
+	flush := func() {
+		switch kind {
+		case 'w':
+			tokens += ceilDiv(run, e.WordChars)
+		case 'd':
+			tokens += ceilDiv(run, e.DigitChars)
+		}
+		run, kind = 0, 0
+	}
+	newline := false
+	for _, r := range text {
+		var current rune
+		switch {
+		case isCJK(r):
+			current = 'c'
+		case unicode.IsDigit(r):
+			current = 'd'
+		case unicode.IsLetter(r) || unicode.IsMark(r):
+			current = 'w'
+		case r == '\n' || r == '\r':
+			current = 'n'
+		case unicode.IsSpace(r):
+			current = 's'
+		default:
+			current = 'p'
+		}
+		if current != kind {
+			flush()
+		}
+		if current != 'n' {
+			newline = false
+		}
+		switch current {
+		case 'c':
+			cjk++
+		case 'd', 'w':
+			kind = current
+			run++
+		case 'n':
+			if !newline {
+				tokens++
+			}
+			newline = true
+		case 'p':
+			tokens++
+		}
+	}
+	flush()
+	return tokens + int(math.Ceil(float64(cjk)*e.CJK))
+}
+
+// isCJK 是否为中日韩字符
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// ceilDiv 向上取整的除法, size小于等于0时按1计算
+func ceilDiv(n int, size int) int {
+	size = max(size, 1)
+	return (n + size - 1) / size
+}
+
+// registry 按照模型名称前缀注册的Tokenizer
+var registry = struct {
+	sync.RWMutex
+	tokenizers map[string]Tokenizer
+}{
+	tokenizers: map[string]Tokenizer{
+		"gpt-":   &Estimator{CJK: 1.1, WordChars: 6, DigitChars: 3},
+		"gpt-4o": &Estimator{CJK: 0.8, WordChars: 6, DigitChars: 3},
+		"glm-":   &Estimator{CJK: 0.7, WordChars: 6, DigitChars: 3},
+		"ERNIE-": &Estimator{CJK: 0.7, WordChars: 6, DigitChars: 3},
+	},
+}
+
+// Register 为模型名称前缀注册Tokenizer, 使用最长匹配的前缀, tokenizer为空时删除, 并发安全
+func Register(prefix string, tokenizer Tokenizer) {
+	registry.Lock()
+	defer registry.Unlock()
+	if tokenizer == nil {
+		delete(registry.tokenizers, prefix)
+		return
+	}
+	registry.tokenizers[prefix] = tokenizer
+}
+
+// For 获取模型的Tokenizer, 多个模型时使用第一个模型, 没有注册时返回Default
+func For(model string) Tokenizer {
+	model, _, _ = strings.Cut(model, ",")
+	registry.RLock()
+	defer registry.RUnlock()
+	var (
+		matched   string
+		tokenizer Tokenizer = Default
+	)
+	for prefix, t := range registry.tokenizers {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			matched, tokenizer = prefix, t
+		}
+	}
+	return tokenizer
+}
+
+// Count 使用模型的Tokenizer计算文本的token数
+func Count(model string, text string) int {
+	return For(model).Count(text)
+}
@@ -0,0 +1,46 @@
+package tokenizer
+
+import "testing"
+
+func TestEstimator_Count(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"english", "hello world!", 3},
+		{"long word", "internationalization", 4},
+		{"chinese", "讲一个笑话", 5},
+		{"chinese punctuation", "你好，世界。", 6},
+		{"mixed", "讲一个joke", 4},
+		{"digits", "2024年10月", 5},
+		{"newlines", "第一行\n\n第二行", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Default.Count(tt.text); got != tt.want {
+				t.Errorf("Count(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFor(t *testing.T) {
+	if got := Count("glm-4", "讲一个笑话"); got != 4 {
+		t.Errorf("glm-4 got %d, want 4", got)
+	}
+	// 使用最长匹配的前缀以及第一个模型
+	if got := For("gpt-4o,glm-4"); got != registry.tokenizers["gpt-4o"] {
+		t.Errorf("got %+v, want gpt-4o tokenizer", got)
+	}
+	if got := For("unknown"); got != Default {
+		t.Errorf("got %+v, want Default", got)
+	}
+
+	Register("custom-", TokenizerFunc(func(text string) int { return len(text) }))
+	defer Register("custom-", nil)
+	if got := Count("custom-1", "hello"); got != 5 {
+		t.Errorf("custom got %d, want 5", got)
+	}
+}
@@ -0,0 +1,120 @@
+package fengchaogo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ijiwei/fengchao-go/tokenizer"
+)
+
+// 预估token数时的固定消耗
+const (
+	// MessageOverheadTokens 每条消息的角色和格式额外消耗的token数
+	MessageOverheadTokens = 4
+	// ImagePartTokens 每张图片预估消耗的token数
+	ImagePartTokens = 765
+)
+
+// ContextCheck 发送前检查消息和最大生成长度是否超过模型的上下文长度
+type ContextCheck int
+
+const (
+	// ContextCheckOff 不检查
+	ContextCheckOff ContextCheck = iota
+	// ContextCheckWarn 超过时记录警告日志, 仍然发送请求
+	ContextCheckWarn
+	// ContextCheckReject 超过时不发送请求, 返回ErrContextLength
+	ContextCheckReject
+)
+
+// WithContextCheck 设置发送前的上下文长度检查, 根据模型列表中的MaxInputToken、MaxOutputToken和ContextWindow判断
+// 模型不在列表中时不检查
+func WithContextCheck(check ContextCheck) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.contextCheck = check
+	}
+}
+
+// CountTokens 使用默认的Tokenizer估算渲染后的Prompt的token数, variables与WithParams相同, 可以是结构体或者map
+func CountTokens(prompt Prompt, variables any) (int, error) {
+	if prompt == nil {
+		return 0, fmt.Errorf("%w: prompt is nil", ErrInvalidParameter)
+	}
+	params := &ChatCompletion{}
+	if variables != nil {
+		WithParams(variables)(params)
+	}
+	messages, err := prompt.RenderMessages(params.variables)
+	if err != nil {
+		return 0, fmt.Errorf("render message template with error[%v]", err)
+	}
+	tokens := 0
+	for _, message := range messages {
+		tokens += countContentTokens(tokenizer.Default, message.Content, message.Parts)
+	}
+	return tokens, nil
+}
+
+// EstimateRequest 使用模型的Tokenizer估算请求的输入token数: 渲染后的系统消息、历史消息和问题, 不包括MaxTokens
+func EstimateRequest(params *ChatCompletion) int {
+	t := tokenizer.For(params.Model)
+	tokens := 0
+	if params.System != "" {
+		tokens += countContentTokens(t, params.System, nil)
+	}
+	for _, message := range params.History {
+		tokens += countContentTokens(t, message.Content, message.Parts)
+		for _, call := range message.ToolCalls {
+			tokens += t.Count(call.Function.Name) + t.Count(call.Function.Arguments)
+		}
+	}
+	return tokens + countContentTokens(t, params.Query, params.QueryParts)
+}
+
+// countContentTokens 估算一条消息的token数
+func countContentTokens(t tokenizer.Tokenizer, content string, parts []ContentPart) int {
+	tokens := MessageOverheadTokens + t.Count(content)
+	for _, part := range parts {
+		switch part.Type {
+		case ContentPartImageURL:
+			tokens += ImagePartTokens
+		default:
+			tokens += t.Count(part.Text)
+		}
+	}
+	return tokens
+}
+
+// checkContextWindow 根据模型列表检查预估的输入token数和最大生成长度是否超过模型的限制,
+// 以及两者之和是否超过模型的上下文长度
+func (f *FengChao) checkContextWindow(ctx context.Context, params *ChatCompletion) error {
+	if params.contextCheck == ContextCheckOff {
+		return nil
+	}
+	model := f.getModel(ctx, params.Model)
+	if model == nil {
+		return nil
+	}
+	input := EstimateRequest(params)
+	var problems []string
+	if model.MaxInputToken > 0 && input > model.MaxInputToken {
+		problems = append(problems, fmt.Sprintf("estimated input tokens %d exceeds max_input_token %d", input, model.MaxInputToken))
+	}
+	if model.MaxOutputToken > 0 && params.MaxTokens > model.MaxOutputToken {
+		problems = append(problems, fmt.Sprintf("max_tokens %d exceeds max_output_token %d", params.MaxTokens, model.MaxOutputToken))
+	}
+	// 模型列表中有ContextWindow时, 输入和最大生成长度之和也需要在上下文长度之内
+	if window := model.contextWindow(); len(problems) == 0 && window > 0 && input+params.MaxTokens > window {
+		problems = append(problems, fmt.Sprintf("estimated input tokens %d plus max_tokens %d exceeds context window %d", input, params.MaxTokens, window))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%w: model %s: %s", ErrContextLength, model.ID, strings.Join(problems, ", "))
+	if params.contextCheck == ContextCheckWarn {
+		f.logger.Warn("fengchao context window exceeded", "request_id", params.RequestID, "model", model.ID, "input_tokens", input, "max_tokens", params.MaxTokens, "error", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+func TestCountTokens(t *testing.T) {
+	prompt := fengchao.NewPromptTemplate(
+		fengchao.NewSystemMessage("你是一个{{.Role}}"),
+		fengchao.NewUserMessage("讲一个joke"),
+	)
+	got, err := fengchao.CountTokens(prompt, map[string]any{"Role": "助手"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 你是一个助手: 6, 讲一个joke: 4, 每条消息额外4个
+	if want := 6 + 4 + 2*fengchao.MessageOverheadTokens; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	if _, err := fengchao.CountTokens(nil, nil); !errors.Is(err, fengchao.ErrInvalidParameter) {
+		t.Fatalf("got %v, want ErrInvalidParameter", err)
+	}
+
+	params := fengchao.NewChatCompletion(fengchao.WithModel("gpt-4o"), fengchao.WithSystem("你好"), fengchao.WithQuery("讲一个笑话"))
+	params.QueryParts = []fengchao.ContentPart{fengchao.ImageURLPart("https://example.com/cat.png")}
+	// 你好: 2*0.8, 讲一个笑话: 5*0.8, 向上取整
+	if got, want := fengchao.EstimateRequest(params), 2+4+2*fengchao.MessageOverheadTokens+fengchao.ImagePartTokens; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+}
+
+func TestContextCheck(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{Content: "好的"})
+	client := server.Client()
+	ctx := context.Background()
+	long := fengchao.NewUserMessage(strings.Repeat("测试", 6000))
+
+	// ERNIE-Bot-4的MaxInputToken为8000
+	_, err := client.ChatCompletion(ctx, long, fengchao.WithContextCheck(fengchao.ContextCheckReject))
+	if !errors.Is(err, fengchao.ErrContextLength) || !strings.Contains(err.Error(), "max_input_token 8000") {
+		t.Fatalf("got %v, want ErrContextLength", err)
+	}
+	_, err = client.ChatCompletionStream(ctx, fengchao.NewUserMessage("你好"),
+		fengchao.WithContextCheck(fengchao.ContextCheckReject),
+		fengchao.WithMaxTokens(3000),
+	)
+	if !errors.Is(err, fengchao.ErrContextLength) || !strings.Contains(err.Error(), "max_output_token 2000") {
+		t.Fatalf("got %v, want ErrContextLength", err)
+	}
+	if len(server.Requests()) != 0 {
+		t.Fatalf("got %d requests, want 0", len(server.Requests()))
+	}
+
+	// 只记录警告和不检查时仍然发送请求
+	if _, err := client.ChatCompletion(ctx, long, fengchao.WithContextCheck(fengchao.ContextCheckWarn)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ChatCompletion(ctx, long); err != nil {
+		t.Fatal(err)
+	}
+	// 上下文更长的模型可以通过检查
+	if _, err := client.ChatCompletion(ctx, long, fengchao.WithModel("glm-4"), fengchao.WithContextCheck(fengchao.ContextCheckReject)); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Requests()) != 3 {
+		t.Fatalf("got %d requests, want 3", len(server.Requests()))
+	}
+}
+
+func TestContextCheckWindow(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{Content: "好的"})
+	server.Models = append(slices.Clone(fengchaotest.DefaultModels),
+		fengchao.Model{ID: "ERNIE-Bot-turbo", MaxInputToken: 8000, MaxOutputToken: 4000, ContextWindow: 10000},
+	)
+	client := server.Client(fengchao.WithChatCompletionOptions(fengchao.WithContextCheck(fengchao.ContextCheckReject)))
+	ctx := context.Background()
+	// 预估约7004个token, 没有超过MaxInputToken
+	long := fengchao.NewUserMessage(strings.Repeat("测试", 5000))
+
+	// 没有ContextWindow时输入和输出分别检查, 接近MaxInputToken的输入使用默认的MaxTokens可以通过
+	if _, err := client.ChatCompletion(ctx, long); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ChatCompletion(ctx, long, fengchao.WithMaxTokens(2000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 有ContextWindow时输入和MaxTokens之和不能超过ContextWindow
+	_, err := client.ChatCompletion(ctx, long, fengchao.WithModel("ERNIE-Bot-turbo"), fengchao.WithMaxTokens(4000))
+	if !errors.Is(err, fengchao.ErrContextLength) || !strings.Contains(err.Error(), "exceeds context window 10000") {
+		t.Fatalf("got %v, want ErrContextLength", err)
+	}
+	_, err = client.ChatCompletionStream(ctx, long, fengchao.WithModel("ERNIE-Bot-turbo"), fengchao.WithMaxTokens(4000))
+	if !errors.Is(err, fengchao.ErrContextLength) {
+		t.Fatalf("got %v, want ErrContextLength", err)
+	}
+	if _, err := client.ChatCompletion(ctx, long, fengchao.WithModel("ERNIE-Bot-turbo"), fengchao.WithMaxTokens(2000)); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Requests()) != 3 {
+		t.Fatalf("got %d requests, want 3", len(server.Requests()))
+	}
+}
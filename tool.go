@@ -0,0 +1,376 @@
+package fengchaogo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ToolTypeFunction 函数类型的工具
+const ToolTypeFunction = "function"
+
+// FinishReasonToolCalls 模型需要调用工具时的结束原因
+const FinishReasonToolCalls = "tool_calls"
+
+// 工具的选择方式
+const (
+	// ToolChoiceAuto 由模型决定是否调用工具
+	ToolChoiceAuto = "auto"
+	// ToolChoiceNone 不调用工具
+	ToolChoiceNone = "none"
+	// ToolChoiceRequired 必须调用工具
+	ToolChoiceRequired = "required"
+)
+
+// DefaultToolTimeout 工具执行的默认超时时间
+const DefaultToolTimeout = 30 * time.Second
+
+// DefaultMaxToolIterations 默认最多调用工具的轮数
+const DefaultMaxToolIterations = 10
+
+// ErrMaxToolIterations 调用工具的轮数超过限制
+var ErrMaxToolIterations = errors.New("max tool iterations exceeded")
+
+// Tool 发送给模型的工具定义
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction 函数的定义
+type ToolFunction struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  *JSONSchema `json:"parameters,omitempty"`
+}
+
+// ToolCall 模型返回的工具调用
+type ToolCall struct {
+	// Index 流式响应中工具调用的位置, 用于拼接分片
+	Index int    `json:"index,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Type  string `json:"type,omitempty"`
+	// Function 调用的函数
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall 调用的函数和参数
+type FunctionCall struct {
+	Name string `json:"name,omitempty"`
+	// Arguments json格式的参数
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// NewToolMessage 创建工具的执行结果消息
+func NewToolMessage(toolCallID string, content string) *Message {
+	return &Message{Role: RoleTool, ToolCallID: toolCallID, Content: content}
+}
+
+// FunctionTool 可以由客户端执行的工具
+type FunctionTool struct {
+	// Name 工具名称
+	Name string
+	// Description 工具的说明, 模型根据说明决定是否调用
+	Description string
+	// Parameters 参数的JSON Schema
+	Parameters *JSONSchema
+	// Timeout 单次执行的超时时间, 为0时使用DefaultToolTimeout
+	Timeout time.Duration
+	// Call 执行工具, arguments为模型生成的json参数, 返回发送给模型的结果
+	Call func(ctx context.Context, arguments string) (string, error)
+}
+
+// WithToolTimeout 设置工具单次执行的超时时间
+func WithToolTimeout(timeout time.Duration) Option[FunctionTool] {
+	return func(option *FunctionTool) {
+		option.Timeout = timeout
+	}
+}
+
+// NewFunctionTool 使用Go函数创建工具, 参数的JSON Schema根据A生成, 参见SchemaFor
+// 模型生成的参数会先按照Schema校验再解析为A, 结果为字符串时直接返回给模型, 否则转换为json
+func NewFunctionTool[A any, R any](name string, description string, fn func(ctx context.Context, args A) (R, error), options ...Option[FunctionTool]) *FunctionTool {
+	schema := SchemaFor[A]()
+	tool := &FunctionTool{
+		Name:        name,
+		Description: description,
+		Parameters:  schema,
+		Call: func(ctx context.Context, arguments string) (string, error) {
+			if arguments == "" {
+				arguments = "{}"
+			}
+			if err := schema.Validate([]byte(arguments)); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			var args A
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			result, err := fn(ctx, args)
+			if err != nil {
+				return "", err
+			}
+			if s, ok := any(result).(string); ok {
+				return s, nil
+			}
+			data, err := json.Marshal(result)
+			if err != nil {
+				return "", fmt.Errorf("marshal tool result: %w", err)
+			}
+			return string(data), nil
+		},
+	}
+	for _, option := range options {
+		option(tool)
+	}
+	return tool
+}
+
+// definition 发送给模型的工具定义
+func (t *FunctionTool) definition() Tool {
+	return Tool{Type: ToolTypeFunction, Function: ToolFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters}}
+}
+
+// ToolRegistry 工具注册表, 并发安全
+type ToolRegistry struct {
+	tools []*FunctionTool
+	mu    sync.RWMutex
+}
+
+// NewToolRegistry 创建工具注册表, 工具名称重复时panic
+func NewToolRegistry(tools ...*FunctionTool) *ToolRegistry {
+	registry := &ToolRegistry{}
+	if err := registry.Register(tools...); err != nil {
+		panic(err)
+	}
+	return registry
+}
+
+// Register 注册工具, 工具名称不能重复
+func (r *ToolRegistry) Register(tools ...*FunctionTool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, tool := range tools {
+		if tool.Name == "" || tool.Call == nil {
+			return errors.New("tool name and call are required")
+		}
+		if slices.ContainsFunc(r.tools, func(t *FunctionTool) bool { return t.Name == tool.Name }) {
+			return fmt.Errorf("tool %s is already registered", tool.Name)
+		}
+		r.tools = append(r.tools, tool)
+	}
+	return nil
+}
+
+// Definitions 获取所有工具的定义, 按照注册的顺序
+func (r *ToolRegistry) Definitions() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	definitions := make([]Tool, 0, len(r.tools))
+	for _, tool := range r.tools {
+		definitions = append(definitions, tool.definition())
+	}
+	return definitions
+}
+
+// lookup 按照名称查找工具
+func (r *ToolRegistry) lookup(name string) *FunctionTool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, tool := range r.tools {
+		if tool.Name == name {
+			return tool
+		}
+	}
+	return nil
+}
+
+// Call 执行一次工具调用, 超过工具的超时时间时立即返回错误, 不会等待没有处理ctx的工具
+func (r *ToolRegistry) Call(ctx context.Context, call ToolCall) (string, error) {
+	tool := r.lookup(call.Function.Name)
+	if tool == nil {
+		return "", fmt.Errorf("tool %s not found", call.Function.Name)
+	}
+	timeout := tool.Timeout
+	if timeout <= 0 {
+		timeout = DefaultToolTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type output struct {
+		result string
+		err    error
+	}
+	done := make(chan output, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- output{err: fmt.Errorf("tool %s panic: %v", call.Function.Name, r)}
+			}
+		}()
+		result, err := tool.Call(ctx, call.Function.Arguments)
+		done <- output{result: result, err: err}
+	}()
+
+	select {
+	case out := <-done:
+		return out.result, out.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("tool %s timed out after %s: %w", call.Function.Name, timeout, ctx.Err())
+		}
+		return "", ctx.Err()
+	}
+}
+
+// executeTools 并发执行一轮工具调用, 按照调用的顺序返回结果消息和错误, 执行失败时将错误信息作为结果返回给模型
+func (f *FengChao) executeTools(ctx context.Context, registry *ToolRegistry, calls []ToolCall) ([]*Message, []error) {
+	messages := make([]*Message, len(calls))
+	errs := make([]error, len(calls))
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			result, err := registry.Call(ctx, call)
+			if err != nil {
+				f.logger.Warn("fengchao tool call failed", "tool", call.Function.Name, "tool_call_id", call.ID, "latency", time.Since(start), "error", err)
+				result = "error: " + err.Error()
+			} else {
+				f.logger.Debug("fengchao tool called", "tool", call.Function.Name, "tool_call_id", call.ID, "latency", time.Since(start))
+			}
+			messages[i] = NewToolMessage(call.ID, result)
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+	return messages, errs
+}
+
+// WithTools 设置发送给模型的工具定义, 需要自行处理模型返回的工具调用时使用
+func WithTools(tools ...Tool) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.Tools = append(option.Tools, tools...)
+	}
+}
+
+// WithToolChoice 设置工具的选择方式, 可选ToolChoiceAuto、ToolChoiceNone、ToolChoiceRequired
+// ChatCompletionWithTools和Agent中ToolChoiceRequired只对第一轮生效, 之后使用ToolChoiceAuto
+func WithToolChoice(choice string) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.ToolChoice = choice
+	}
+}
+
+// WithMaxToolIterations 设置ChatCompletionWithTools最多调用工具的轮数
+func WithMaxToolIterations(iterations int) Option[ChatCompletion] {
+	return func(option *ChatCompletion) {
+		option.maxToolIterations = &iterations
+	}
+}
+
+// ChatCompletionWithTools 聊天并自动执行模型调用的工具, 将执行结果以tool角色返回给模型, 直到模型给出最终的回答
+// 工具执行失败时将错误信息返回给模型; 调用工具的轮数超过限制时返回最后一次的结果和ErrMaxToolIterations
+// 返回结果的History包含完整的对话过程, Usage为所有请求的累计消耗
+func (f *FengChao) ChatCompletionWithTools(ctx context.Context, prompt Prompt, registry *ToolRegistry, chatCompletionOption ...Option[ChatCompletion]) (*ChatCompletionResult, error) {
+	params := f.newChatCompletion(chatCompletionOption...)
+	if _, err := params.LoadPromptTemplates(prompt); err != nil {
+		return nil, fmt.Errorf("fail to load prompt template cause: %w", err)
+	}
+	params.Tools = append(params.Tools, registry.Definitions()...)
+	maxIterations := DefaultMaxToolIterations
+	if params.maxToolIterations != nil {
+		maxIterations = *params.maxToolIterations
+	}
+
+	messages := append(slices.Clone(params.History), &Message{Role: RoleUser, Content: params.Query, Parts: params.QueryParts})
+	var usage ChatCompletionResult
+	for iteration := 0; ; iteration++ {
+		result, err := f.chat(ctx, RequestInvoke, params)
+		if result != nil {
+			usage.Usage.PromptTokens += result.Usage.PromptTokens
+			usage.Usage.CompletionTokens += result.Usage.CompletionTokens
+			usage.Usage.TotalTokens += result.Usage.TotalTokens
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Usage = usage.Usage
+
+		var reply Message
+		if len(result.Choices) > 0 {
+			reply = result.Choices[0].Message
+		}
+		messages = append(messages, &Message{Role: RoleAssistant, Content: reply.Content, ToolCalls: reply.ToolCalls})
+		result.History = messages
+		if len(reply.ToolCalls) == 0 {
+			return result, nil
+		}
+		if iteration >= maxIterations {
+			return result, fmt.Errorf("%w: the model still calls tools after %d iterations", ErrMaxToolIterations, maxIterations)
+		}
+
+		results, _ := f.executeTools(ctx, registry, reply.ToolCalls)
+		messages = append(messages, results...)
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		// 工具的执行结果通过历史消息发送
+		params = params.Clone()
+		params.History = slices.Clone(messages)
+		params.Query = ""
+		params.QueryParts = nil
+		// 必须调用工具只对第一轮生效, 之后由模型决定是否继续调用工具并给出回答
+		if params.ToolChoice == ToolChoiceRequired {
+			params.ToolChoice = ToolChoiceAuto
+		}
+	}
+}
+
+// ToolCallAccumulator 拼接流式响应中的工具调用分片
+// 同一个工具调用的分片使用相同的Index, 第一个分片包含ID和函数名称, 之后的分片包含参数的片段
+type ToolCallAccumulator struct {
+	calls []ToolCall
+}
+
+// Add 添加一个数据包中的工具调用分片
+func (a *ToolCallAccumulator) Add(deltas ...ToolCall) {
+	for _, delta := range deltas {
+		i := slices.IndexFunc(a.calls, func(c ToolCall) bool {
+			return c.Index == delta.Index && (delta.ID == "" || c.ID == "" || c.ID == delta.ID)
+		})
+		if i < 0 {
+			a.calls = append(a.calls, delta)
+			continue
+		}
+		call := &a.calls[i]
+		if call.ID == "" {
+			call.ID = delta.ID
+		}
+		if call.Type == "" {
+			call.Type = delta.Type
+		}
+		if call.Function.Name == "" {
+			call.Function.Name = delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
+	}
+}
+
+// AddResult 添加流式数据包中所有的工具调用分片
+func (a *ToolCallAccumulator) AddResult(result *ChatCompletionResult) {
+	for _, choice := range result.Choices {
+		a.Add(choice.Message.ToolCalls...)
+	}
+}
+
+// ToolCalls 获取拼接完成的工具调用
+func (a *ToolCallAccumulator) ToolCalls() []ToolCall {
+	return slices.Clone(a.calls)
+}
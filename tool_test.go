@@ -0,0 +1,200 @@
+package fengchaogo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	fengchao "github.com/ijiwei/fengchao-go"
+	"github.com/ijiwei/fengchao-go/fengchaotest"
+)
+
+type weatherArgs struct {
+	City string `json:"city" description:"城市名称"`
+	Unit string `json:"unit,omitempty" enum:"celsius,fahrenheit"`
+}
+
+type weather struct {
+	City        string `json:"city"`
+	Temperature int    `json:"temperature"`
+}
+
+func weatherTool() *fengchao.FunctionTool {
+	return fengchao.NewFunctionTool("get_weather", "查询城市的天气", func(ctx context.Context, args weatherArgs) (weather, error) {
+		if args.City == "火星" {
+			return weather{}, errors.New("city not supported")
+		}
+		return weather{City: args.City, Temperature: 25}, nil
+	})
+}
+
+func TestChatCompletionWithTools(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.OnQuery("北京和火星的天气怎么样", fengchaotest.Reply{
+		ToolCalls: []fengchao.ToolCall{
+			{ID: "call_1", Type: fengchao.ToolTypeFunction, Function: fengchao.FunctionCall{Name: "get_weather", Arguments: `{"city": "北京"}`}},
+			{ID: "call_2", Type: fengchao.ToolTypeFunction, Function: fengchao.FunctionCall{Name: "get_weather", Arguments: `{"city": "火星"}`}},
+		},
+		PromptTokens: 10, CompletionTokens: 5,
+	})
+	server.SetDefault(fengchaotest.Reply{Content: "北京晴, 25度; 火星查询不到", PromptTokens: 30, CompletionTokens: 10})
+	client := server.Client()
+
+	result, err := client.ChatCompletionWithTools(context.Background(),
+		fengchao.NewMessage(fengchao.RoleUser, "北京和火星的天气怎么样"),
+		fengchao.NewToolRegistry(weatherTool()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "北京晴, 25度; 火星查询不到" {
+		t.Fatalf("got %q", result.String())
+	}
+	if result.Usage.TotalTokens != 55 {
+		t.Fatalf("got total tokens %d, want usage of both requests", result.Usage.TotalTokens)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	tools := requests[0].Tools
+	if len(tools) != 1 || tools[0].Function.Name != "get_weather" || tools[0].Function.Parameters.Properties["city"].Description != "城市名称" {
+		t.Fatalf("got tools %+v", tools)
+	}
+
+	history := requests[1].History
+	if len(history) != 4 || history[1].Role != fengchao.RoleAssistant || len(history[1].ToolCalls) != 2 {
+		t.Fatalf("got history %+v", history)
+	}
+	if history[2].Role != fengchao.RoleTool || history[2].ToolCallID != "call_1" || history[2].Content != `{"city":"北京","temperature":25}` {
+		t.Fatalf("got first tool result %+v", history[2])
+	}
+	if history[3].ToolCallID != "call_2" || history[3].Content != "error: city not supported" {
+		t.Fatalf("got second tool result %+v", history[3])
+	}
+	if len(result.History) != 5 || result.History[4].Content != result.String() {
+		t.Fatalf("got result history %+v", result.History)
+	}
+}
+
+func TestChatCompletionWithToolsRequiredOnce(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.On(func(*fengchao.ChatCompletion) bool { return true },
+		fengchaotest.Reply{ToolCalls: []fengchao.ToolCall{{ID: "call_1", Type: fengchao.ToolTypeFunction, Function: fengchao.FunctionCall{Name: "get_weather", Arguments: `{"city": "北京"}`}}}},
+		fengchaotest.Reply{Content: "北京晴, 25度"},
+	)
+	client := server.Client()
+
+	result, err := client.ChatCompletionWithTools(context.Background(),
+		fengchao.NewMessage(fengchao.RoleUser, "北京天气怎么样"),
+		fengchao.NewToolRegistry(weatherTool()),
+		fengchao.WithToolChoice(fengchao.ToolChoiceRequired),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "北京晴, 25度" {
+		t.Fatalf("got %q", result.String())
+	}
+	// 必须调用工具只对第一轮生效
+	requests := server.Requests()
+	if len(requests) != 2 || requests[0].ToolChoice != fengchao.ToolChoiceRequired || requests[1].ToolChoice != fengchao.ToolChoiceAuto {
+		t.Fatalf("got %d requests %+v", len(requests), requests)
+	}
+}
+
+func TestChatCompletionWithToolsMaxIterations(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	server.SetDefault(fengchaotest.Reply{ToolCalls: []fengchao.ToolCall{
+		{ID: "call", Function: fengchao.FunctionCall{Name: "get_weather", Arguments: `{"city": "北京"}`}},
+	}})
+	client := server.Client()
+
+	_, err := client.ChatCompletionWithTools(context.Background(),
+		fengchao.NewMessage(fengchao.RoleUser, "北京天气怎么样"),
+		fengchao.NewToolRegistry(weatherTool()),
+		fengchao.WithMaxToolIterations(2),
+	)
+	if !errors.Is(err, fengchao.ErrMaxToolIterations) {
+		t.Fatalf("got %v, want ErrMaxToolIterations", err)
+	}
+	if len(server.Requests()) != 3 {
+		t.Fatalf("got %d requests, want 3", len(server.Requests()))
+	}
+}
+
+func TestToolRegistryCall(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	registry := fengchao.NewToolRegistry(
+		weatherTool(),
+		fengchao.NewFunctionTool("slow", "不处理ctx的工具", func(ctx context.Context, args struct{}) (string, error) {
+			<-block
+			return "done", nil
+		}, fengchao.WithToolTimeout(50*time.Millisecond)),
+		fengchao.NewFunctionTool("panic", "会panic的工具", func(ctx context.Context, args struct{}) (string, error) {
+			panic("boom")
+		}),
+	)
+	if err := registry.Register(weatherTool()); err == nil {
+		t.Fatal("want duplicate tool error")
+	}
+
+	tests := []struct {
+		name, arguments, want string
+	}{
+		{"get_weather", `{"city": "上海", "unit": "celsius"}`, ""},
+		{"get_weather", `{"unit": "celsius"}`, `missing required field "city"`},
+		{"get_weather", `{"city": "上海", "unit": "kelvin"}`, "$.unit: must be one of"},
+		{"get_weather", `not json`, "invalid arguments"},
+		{"slow", "", "timed out after 50ms"},
+		{"panic", "{}", "panic: boom"},
+		{"missing", "{}", "tool missing not found"},
+	}
+	for _, tt := range tests {
+		_, err := registry.Call(context.Background(), fengchao.ToolCall{Function: fengchao.FunctionCall{Name: tt.name, Arguments: tt.arguments}})
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("call %s(%s): got %v, want %q", tt.name, tt.arguments, err, tt.want)
+		}
+	}
+}
+
+func TestToolCallStream(t *testing.T) {
+	server := fengchaotest.NewServer()
+	defer server.Close()
+	calls := []fengchao.ToolCall{
+		{ID: "call_1", Type: fengchao.ToolTypeFunction, Function: fengchao.FunctionCall{Name: "get_weather", Arguments: `{"city": "北京"}`}},
+		{Index: 1, ID: "call_2", Type: fengchao.ToolTypeFunction, Function: fengchao.FunctionCall{Name: "get_weather", Arguments: `{"city": "上海"}`}},
+	}
+	server.SetDefault(fengchaotest.Reply{ToolCalls: calls})
+	client := server.Client()
+
+	reader, err := client.ChatCompletionStream(context.Background(), fengchao.NewMessage(fengchao.RoleUser, "北京和上海的天气"),
+		fengchao.WithTools(fengchao.NewToolRegistry(weatherTool()).Definitions()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accumulator fengchao.ToolCallAccumulator
+	finishReason := ""
+	reader.OnMessage(func(msg *fengchao.ChatCompletionResult) {
+		if len(msg.Choices) > 0 && msg.Choices[0].FinishReason != "" {
+			finishReason = msg.Choices[0].FinishReason
+		}
+	})
+	for msg := range reader.Stream() {
+		accumulator.AddResult(&msg)
+	}
+	if got, want := fmt.Sprintf("%+v", accumulator.ToolCalls()), fmt.Sprintf("%+v", calls); got != want {
+		t.Fatalf("got tool calls %s, want %s", got, want)
+	}
+	if finishReason != fengchao.FinishReasonToolCalls {
+		t.Fatalf("got finish reason %q", finishReason)
+	}
+}
@@ -0,0 +1,121 @@
+package fengchaogo
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName OpenTelemetry instrumentation名称
+const tracerName = "github.com/ijiwei/fengchao-go"
+
+// span属性
+const (
+	attrRequestKind      = attribute.Key("fengchao.request.kind")
+	attrRequestID        = attribute.Key("fengchao.request_id")
+	attrRetryCount       = attribute.Key("fengchao.retry_count")
+	attrTimeToFirstChunk = attribute.Key("fengchao.time_to_first_chunk_ms")
+	attrStatus           = attribute.Key("fengchao.status")
+	attrBatchSize        = attribute.Key("fengchao.batch.size")
+	attrBatchFailed      = attribute.Key("fengchao.batch.failed")
+	attrModel            = attribute.Key("gen_ai.request.model")
+	attrMaxTokens        = attribute.Key("gen_ai.request.max_tokens")
+	attrTemperature      = attribute.Key("gen_ai.request.temperature")
+	attrInputTokens      = attribute.Key("gen_ai.usage.input_tokens")
+	attrOutputTokens     = attribute.Key("gen_ai.usage.output_tokens")
+	attrFinishReasons    = attribute.Key("gen_ai.response.finish_reasons")
+)
+
+// spanNames 不同请求类型的span名称
+var spanNames = map[RequestKind]string{
+	RequestToken:  "fengchao.refresh_token",
+	RequestModels: "fengchao.load_models",
+	RequestInvoke: "fengchao.chat_completion",
+	RequestQuick:  "fengchao.quick_completion",
+	RequestStream: "fengchao.chat_completion_stream",
+	requestBatch:  "fengchao.batch_chat_completion",
+}
+
+// requestBatch 批量请求, 只用于链路追踪
+const requestBatch RequestKind = "batch"
+
+// defaultTracer 未配置TracerProvider时不记录链路
+var defaultTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// startSpan 创建span
+func (f *FengChao) startSpan(ctx context.Context, kind RequestKind, params *ChatCompletion) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attrRequestKind.String(string(kind))}
+	if params != nil {
+		attrs = append(attrs,
+			attrRequestID.String(params.RequestID),
+			attrModel.String(params.Model),
+			attrMaxTokens.Int(params.MaxTokens),
+			attrTemperature.Float64(params.Temperature),
+		)
+	}
+	spanKind := trace.SpanKindClient
+	if kind == requestBatch {
+		spanKind = trace.SpanKindInternal
+	}
+	return f.tracer.Start(ctx, spanNames[kind], trace.WithSpanKind(spanKind), trace.WithAttributes(attrs...))
+}
+
+// endSpan 记录结果并结束span
+func endSpan(span trace.Span, result *ChatCompletionResult, err error) {
+	if result != nil {
+		setResultAttributes(span, result)
+	}
+	if err != nil {
+		// 获取token失败时错误中可能包含api_key和secret_key, 只记录脱敏后的错误
+		err = redactError(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// setResultAttributes 记录对话结果的属性
+func setResultAttributes(span trace.Span, result *ChatCompletionResult) {
+	finishReasons := make([]string, 0, len(result.Choices))
+	for _, choice := range result.Choices {
+		if choice.FinishReason != "" {
+			finishReasons = append(finishReasons, choice.FinishReason)
+		}
+	}
+	span.SetAttributes(
+		attrStatus.Int(result.Status),
+		attrInputTokens.Int(result.Usage.PromptTokens),
+		attrOutputTokens.Int(result.Usage.CompletionTokens),
+	)
+	if len(finishReasons) > 0 {
+		span.SetAttributes(attrFinishReasons.StringSlice(finishReasons))
+	}
+}
+
+// traceStream 记录数据流的首包时间和结果, span在数据流关闭时结束
+func traceStream(span trace.Span, reader *JsonStreamReader[ChatCompletionResult]) {
+	start := time.Now()
+	first := true
+	var last *ChatCompletionResult
+	reader.OnMessage(func(msg *ChatCompletionResult) {
+		if first {
+			first = false
+			span.SetAttributes(attrTimeToFirstChunk.Int64(time.Since(start).Milliseconds()))
+			span.AddEvent("first_chunk")
+		}
+		last = msg
+	})
+	reader.OnClose(func(err error) {
+		endSpan(span, last, err)
+	})
+}
+
+// injectTraceContext 将链路信息写入请求头
+func (f *FengChao) injectTraceContext(ctx context.Context, req *Request) {
+	f.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
@@ -0,0 +1,160 @@
+package fengchaogo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTracingTestServer 返回固定结果的测试服务, traced为true时校验请求头中的链路信息
+func newTracingTestServer(t *testing.T, traced bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"status": 200, "token": "token"})
+			return
+		}
+		if traced && r.Header.Get("Traceparent") == "" {
+			t.Errorf("%s missing traceparent header", r.URL.Path)
+		}
+		var params ChatCompletion
+		json.NewDecoder(r.Body).Decode(&params)
+		if params.Mode == StreamMode {
+			fmt.Fprint(w, "event: add\ndata: {\"status\":200,\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":\"hi\"}}]}\n\n")
+			fmt.Fprint(w, "event: stop\ndata: {\"status\":200,\"choices\":[{\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":5}}\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"request_id": params.RequestID,
+			"status":     200,
+			"choices":    []map[string]any{{"finish_reason": "stop", "message": map[string]any{"role": RoleAssistant, "content": "hi"}}},
+			"usage":      map[string]any{"prompt_tokens": 3, "completion_tokens": 5, "total_tokens": 8},
+		})
+	}))
+}
+
+// spanAttributes 获取span的属性
+func spanAttributes(span tracetest.SpanStub) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range span.Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	return attrs
+}
+
+func TestFengChao_Tracing(t *testing.T) {
+	server := newTracingTestServer(t, true)
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	client := NewFengChao("key", "secret", server.URL, WithTracerProvider(provider))
+
+	if _, err := client.ChatCompletion(context.Background(), NewUserMessage("hello"), WithModel("glm-4"), WithRequestID("abc")); err != nil {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+
+	reader, err := client.ChatCompletionStream(context.Background(), NewUserMessage("hello"))
+	if err != nil {
+		t.Fatalf("ChatCompletionStream() error = %v", err)
+	}
+	for range reader.Stream() {
+	}
+
+	builder := NewBatchChatCompletionBuilder()
+	builder.Add(NewUserMessage("one"))
+	builder.Add(NewUserMessage("two"))
+	client.BatchChatCompletion(context.Background(), builder)
+
+	spans := exporter.GetSpans()
+	byName := make(map[string][]tracetest.SpanStub)
+	for _, span := range spans {
+		byName[span.Name] = append(byName[span.Name], span)
+	}
+
+	if len(byName["fengchao.refresh_token"]) != 1 {
+		t.Errorf("refresh_token spans = %d, want 1", len(byName["fengchao.refresh_token"]))
+	}
+
+	chat := byName["fengchao.chat_completion"]
+	if len(chat) != 3 {
+		t.Fatalf("chat_completion spans = %d, want 3", len(chat))
+	}
+	attrs := spanAttributes(chat[0])
+	for key, want := range map[string]string{
+		"gen_ai.request.model":           "glm-4",
+		"fengchao.request_id":            "abc",
+		"gen_ai.usage.input_tokens":      "3",
+		"gen_ai.usage.output_tokens":     "5",
+		"gen_ai.response.finish_reasons": `["stop"]`,
+		"fengchao.retry_count":           "0",
+	} {
+		if attrs[key] != want {
+			t.Errorf("chat_completion attribute %s = %q, want %q", key, attrs[key], want)
+		}
+	}
+
+	stream := byName["fengchao.chat_completion_stream"]
+	if len(stream) != 1 {
+		t.Fatalf("chat_completion_stream spans = %d, want 1", len(stream))
+	}
+	if _, ok := spanAttributes(stream[0])["fengchao.time_to_first_chunk_ms"]; !ok {
+		t.Error("chat_completion_stream missing time_to_first_chunk_ms")
+	}
+	if got := spanAttributes(stream[0])["gen_ai.response.finish_reasons"]; got != `["stop"]` {
+		t.Errorf("chat_completion_stream finish_reasons = %q, want %q", got, `["stop"]`)
+	}
+
+	batch := byName["fengchao.batch_chat_completion"]
+	if len(batch) != 1 {
+		t.Fatalf("batch_chat_completion spans = %d, want 1", len(batch))
+	}
+	children := 0
+	for _, span := range chat {
+		if span.Parent.SpanID() == batch[0].SpanContext.SpanID() {
+			children++
+		}
+	}
+	if children != 2 {
+		t.Errorf("batch children = %d, want 2", children)
+	}
+}
+
+func TestFengChao_TracingRedactsTokenError(t *testing.T) {
+	const secretKey = "secret-key-1234567890"
+	// 关闭的服务地址, 获取token时返回连接错误
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	client := NewFengChao("key", secretKey, server.URL, WithTracerProvider(provider))
+	if _, err := client.ChatCompletion(context.Background(), NewUserMessage("hello")); err == nil {
+		t.Fatal("want token error")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) == 0 {
+		t.Fatal("want spans")
+	}
+	for _, span := range spans {
+		if strings.Contains(span.Status.Description, secretKey) {
+			t.Errorf("span %s status contains secret: %s", span.Name, span.Status.Description)
+		}
+		for _, event := range span.Events {
+			for _, attr := range event.Attributes {
+				if strings.Contains(attr.Value.Emit(), secretKey) {
+					t.Errorf("span %s event %s contains secret: %s", span.Name, event.Name, attr.Value.Emit())
+				}
+			}
+		}
+	}
+}